@@ -31,3 +31,28 @@ func StartPublicIpValidator() {
 		}
 	}()
 }
+
+func StartScheduledBlogPublisher() {
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for {
+			handlers.PublishScheduledBlogs()
+			<-ticker.C
+		}
+	}()
+}
+
+func StartWebmentionVerifier() {
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for {
+			handlers.VerifyPendingWebmentions()
+			select {
+			case <-ticker.C:
+			case <-handlers.WebmentionQueued():
+			}
+		}
+	}()
+}
@@ -26,11 +26,190 @@ func InitDatabase() error {
 	CREATE TABLE IF NOT EXISTS blogs (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		title TEXT NOT NULL,
+		slug TEXT,
 		content TEXT NOT NULL,
+		content_format TEXT NOT NULL DEFAULT 'markdown',
+		content_html TEXT NOT NULL DEFAULT '',
 		author TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'published',
+		publish_at DATETIME,
+		category TEXT NOT NULL DEFAULT '',
+		series TEXT NOT NULL DEFAULT '',
+		series_order INTEGER NOT NULL DEFAULT 0,
+		meta_description TEXT NOT NULL DEFAULT '',
+		canonical_url TEXT NOT NULL DEFAULT '',
+		word_count INTEGER NOT NULL DEFAULT 0,
+		reading_time INTEGER NOT NULL DEFAULT 0,
+		toc TEXT NOT NULL DEFAULT '[]',
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
+
+	CREATE TABLE IF NOT EXISTS tags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE
+	);
+
+	CREATE TABLE IF NOT EXISTS blog_tags (
+		blog_id INTEGER NOT NULL,
+		tag_id INTEGER NOT NULL,
+		PRIMARY KEY (blog_id, tag_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_blog_tags_tag_id ON blog_tags(tag_id);
+
+	CREATE VIRTUAL TABLE IF NOT EXISTS blogs_fts USING fts5(
+		title,
+		body,
+		tokenize = 'porter unicode61'
+	);
+
+	CREATE VIRTUAL TABLE IF NOT EXISTS blogs_fts_terms USING fts5vocab(blogs_fts, instance);
+
+	CREATE TABLE IF NOT EXISTS blog_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		blog_id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		content TEXT NOT NULL,
+		content_format TEXT NOT NULL,
+		author TEXT NOT NULL,
+		saved_by TEXT NOT NULL DEFAULT '',
+		restored_from INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_blog_revisions_blog_id ON blog_revisions(blog_id);
+
+	CREATE TABLE IF NOT EXISTS blog_slug_redirects (
+		old_slug TEXT PRIMARY KEY,
+		blog_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	
+	CREATE TABLE IF NOT EXISTS media (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		hash TEXT NOT NULL UNIQUE,
+		file_name TEXT NOT NULL,
+		mime_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		alt_text TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	
+	CREATE TABLE IF NOT EXISTS comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		blog_id INTEGER NOT NULL,
+		parent_id INTEGER,
+		author_name TEXT NOT NULL,
+		author_email TEXT NOT NULL DEFAULT '',
+		content TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		ip_address TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_comments_blog_id ON comments(blog_id, status);
+	CREATE INDEX IF NOT EXISTS idx_comments_status ON comments(status, created_at);
+	CREATE INDEX IF NOT EXISTS idx_comments_ip_address ON comments(ip_address, created_at);
+
+	CREATE TABLE IF NOT EXISTS webmentions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		blog_id INTEGER NOT NULL,
+		source TEXT NOT NULL,
+		target TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		type TEXT NOT NULL DEFAULT 'mention',
+		author_name TEXT NOT NULL DEFAULT '',
+		author_url TEXT NOT NULL DEFAULT '',
+		author_photo TEXT NOT NULL DEFAULT '',
+		content TEXT NOT NULL DEFAULT '',
+		url TEXT NOT NULL DEFAULT '',
+		published_at DATETIME,
+		error TEXT NOT NULL DEFAULT '',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		ip_address TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(source, target)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webmentions_blog_id ON webmentions(blog_id, status);
+	CREATE INDEX IF NOT EXISTS idx_webmentions_status ON webmentions(status, updated_at);
+	CREATE INDEX IF NOT EXISTS idx_webmentions_ip_address ON webmentions(ip_address, updated_at);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT 'viewer',
+		must_change_password INTEGER NOT NULL DEFAULT 0,
+		totp_secret TEXT NOT NULL DEFAULT '',
+		totp_enabled INTEGER NOT NULL DEFAULT 0,
+		totp_last_step INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		password_changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		ip_address TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id, expires_at);
+
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		used_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens(session_id);
+
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id, code_hash);
+
+	CREATE TABLE IF NOT EXISTS login_failures (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ip_address TEXT NOT NULL,
+		username TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_login_failures_ip_address ON login_failures(ip_address, created_at);
+	CREATE INDEX IF NOT EXISTS idx_login_failures_username ON login_failures(username, created_at);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL UNIQUE,
+		key_hash TEXT NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '',
+		expires_at DATETIME,
+		last_used_at DATETIME,
+		last_used_ip TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
 	
 	CREATE TABLE IF NOT EXISTS user_playtime (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -73,6 +252,10 @@ func InitDatabase() error {
 		return err
 	}
 
+	if err = runMigrations(); err != nil {
+		return err
+	}
+
 	log.Println("Database initialized successfully")
 	return nil
 }
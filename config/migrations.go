@@ -0,0 +1,173 @@
+package config
+
+import (
+	"fmt"
+)
+
+// runMigrations brings databases created by older versions up to the current schema
+func runMigrations() error {
+	// Posts written before Markdown support are raw HTML and must stay that way
+	added, err := addColumnIfMissing("blogs", "content_format", "TEXT NOT NULL DEFAULT 'markdown'")
+	if err != nil {
+		return err
+	}
+	if added {
+		if _, err := DB.Exec("UPDATE blogs SET content_format = 'html'"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := addColumnIfMissing("blogs", "content_html", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// Existing posts get their slugs generated by handlers.BackfillBlogSlugs on startup
+	if _, err := addColumnIfMissing("blogs", "slug", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_blogs_slug ON blogs(slug)"); err != nil {
+		return err
+	}
+
+	// Every post that existed before publication states was already live
+	if _, err := addColumnIfMissing("blogs", "status", "TEXT NOT NULL DEFAULT 'published'"); err != nil {
+		return err
+	}
+	added, err = addColumnIfMissing("blogs", "publish_at", "DATETIME")
+	if err != nil {
+		return err
+	}
+	if added {
+		if _, err := DB.Exec("UPDATE blogs SET publish_at = created_at"); err != nil {
+			return err
+		}
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_blogs_status_publish_at ON blogs(status, publish_at)"); err != nil {
+		return err
+	}
+
+	if _, err := addColumnIfMissing("blogs", "category", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_blogs_category ON blogs(category)"); err != nil {
+		return err
+	}
+
+	if _, err := addColumnIfMissing("blogs", "series", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err := addColumnIfMissing("blogs", "series_order", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_blogs_series ON blogs(series, series_order)"); err != nil {
+		return err
+	}
+
+	if _, err := addColumnIfMissing("blogs", "meta_description", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err := addColumnIfMissing("blogs", "canonical_url", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	if _, err := addColumnIfMissing("blogs", "word_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := addColumnIfMissing("blogs", "reading_time", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// Clearing the rendered HTML makes handlers.RenderPendingBlogs re-render every post on startup,
+	// adding heading anchors and filling in the outline columns
+	added, err = addColumnIfMissing("blogs", "toc", "TEXT NOT NULL DEFAULT '[]'")
+	if err != nil {
+		return err
+	}
+	if added {
+		if _, err := DB.Exec("UPDATE blogs SET content_html = ''"); err != nil {
+			return err
+		}
+	}
+
+	// Indexes backing each listing sort order in handlers/blog_pagination.go
+	_, err = DB.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_blogs_posted ON blogs(COALESCE(publish_at, created_at), id);
+		CREATE INDEX IF NOT EXISTS idx_blogs_updated ON blogs(updated_at, id);
+		CREATE INDEX IF NOT EXISTS idx_blogs_title ON blogs(title COLLATE NOCASE, id);`)
+	if err != nil {
+		return err
+	}
+
+	// Accounts created before roles existed could already do everything
+	added, err = addColumnIfMissing("users", "role", "TEXT NOT NULL DEFAULT 'viewer'")
+	if err != nil {
+		return err
+	}
+	if added {
+		if _, err := DB.Exec("UPDATE users SET role = 'admin'"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := addColumnIfMissing("users", "totp_secret", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err := addColumnIfMissing("users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := addColumnIfMissing("users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// Give posts written before revision history a starting revision to diff and restore against
+	_, err = DB.Exec(`
+		INSERT INTO blog_revisions (blog_id, title, content, content_format, author, created_at)
+		SELECT id, title, content, content_format, author, updated_at FROM blogs
+		WHERE id NOT IN (SELECT blog_id FROM blog_revisions)`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// addColumnIfMissing adds a column to an existing table, reporting whether it had to be added
+func addColumnIfMissing(table string, column string, definition string) (bool, error) {
+	exists, err := columnExists(table, column)
+	if err != nil || exists {
+		return false, err
+	}
+
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("failed to add %s.%s: %w", table, column, err)
+	}
+
+	return true, nil
+}
+
+func columnExists(table string, column string) (bool, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal any
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/middleware"
+	"PersonalWebsiteGO/models"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits on the API keys a user can create
+const (
+	maxAPIKeysPerUser    = 20
+	maxAPIKeyNameLength  = 100
+	maxAPIKeyExpiresDays = 3650
+)
+
+const apiKeyColumns = "id, user_id, name, prefix, scopes, expires_at, last_used_at, last_used_ip, created_at"
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays is how many days the key works for, or 0 for a key that does not expire
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &expiresAt, &lastUsedAt, &key.LastUsedIP, &key.CreatedAt)
+	key.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return key, err
+}
+
+// queryAPIKeys selects API keys using the given WHERE/ORDER BY clause
+func queryAPIKeys(clause string, args ...any) ([]models.APIKey, error) {
+	rows, err := config.DB.Query("SELECT "+apiKeyColumns+" FROM api_keys "+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetAPIKeys lists the signed in user's API keys, newest first
+func GetAPIKeys(c *fiber.Ctx) error {
+	identity, _ := middleware.CurrentUser(c)
+	keys, err := queryAPIKeys("WHERE user_id = ? ORDER BY created_at DESC, id DESC", identity.UserID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(keys)
+}
+
+// CreateAPIKey makes an API key for the signed in user to use in scripts, limited to the scopes given. Users
+// can only give keys scopes their role allows. The key is returned once, and cannot be seen again.
+func CreateAPIKey(c *fiber.Ctx) error {
+	var req createAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	identity, _ := middleware.CurrentUser(c)
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > maxAPIKeyNameLength {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Name must be 1 to %d characters", maxAPIKeyNameLength)})
+	}
+	if len(req.Scopes) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "At least one scope is required"})
+	}
+	for _, scope := range req.Scopes {
+		if !models.IsValidScope(scope) {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Unknown scope %q", scope)})
+		}
+		if !middleware.RoleAtLeast(identity.Role, models.ScopeRoles[scope]) {
+			return c.Status(403).JSON(fiber.Map{"error": fmt.Sprintf("Your role cannot create keys with the %s scope", scope)})
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPIKeyExpiresDays {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("expires_in_days must be between 0 and %d", maxAPIKeyExpiresDays)})
+	}
+
+	var count int
+	if err := config.DB.QueryRow("SELECT COUNT(*) FROM api_keys WHERE user_id = ?", identity.UserID).Scan(&count); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if count >= maxAPIKeysPerUser {
+		return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("You already have %d API keys; delete one first", maxAPIKeysPerUser)})
+	}
+
+	key, prefix, hash, err := middleware.GenerateAPIKey()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate API key"})
+	}
+
+	// A NULL expiry never passes
+	var expires any
+	if req.ExpiresInDays > 0 {
+		expires = fmt.Sprintf("+%d days", req.ExpiresInDays)
+	}
+	result, err := config.DB.Exec(
+		"INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, datetime('now', ?))",
+		identity.UserID, req.Name, prefix, hash, strings.Join(req.Scopes, " "), expires,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	id, _ := result.LastInsertId()
+
+	apiKey, err := scanAPIKey(config.DB.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?", id))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("User %s created API key %s (%s) with scopes %s", identity.Username, apiKey.Prefix, apiKey.Name, strings.Join(apiKey.Scopes, ", ")))
+	return c.Status(201).JSON(fiber.Map{
+		"api_key": apiKey,
+		"key":     key,
+		"message": "Copy the key now, it will not be shown again",
+	})
+}
+
+// DeleteAPIKey revokes one of the signed in user's API keys
+func DeleteAPIKey(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid API key ID"})
+	}
+
+	identity, _ := middleware.CurrentUser(c)
+	apiKey, err := scanAPIKey(config.DB.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ? AND user_id = ?", id, identity.UserID))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "API key not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if _, err := config.DB.Exec("DELETE FROM api_keys WHERE id = ?", apiKey.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("User %s deleted API key %s (%s)", identity.Username, apiKey.Prefix, apiKey.Name))
+	return c.JSON(fiber.Map{"message": "API key deleted successfully"})
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"PersonalWebsiteGO/config"
 	"PersonalWebsiteGO/middleware"
-	"os"
+	"PersonalWebsiteGO/models"
+	"fmt"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -13,6 +15,17 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// RefreshRequest represents the refresh token request body, which browsers leave out to use their refresh token cookie
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ChangePasswordRequest represents the change password request body
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
 // Login handles user authentication
 func Login(c *fiber.Ctx) error {
 	var loginReq LoginRequest
@@ -22,34 +35,234 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check credentials against environment variables
-	adminUsername := os.Getenv("ADMIN_USERNAME")
-	adminPassword := os.Getenv("ADMIN_PASSWORD")
+	// Addresses and usernames with too many recent failures are turned away without checking the password
+	wait, err := loginLockedFor(c.IP(), loginReq.Username)
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to check login attempts: %v", err))
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to check credentials",
+		})
+	}
+	if wait > 0 {
+		return sendLoginLocked(c, wait)
+	}
 
-	if loginReq.Username != adminUsername || loginReq.Password != adminPassword {
+	user, ok, err := authenticateUser(loginReq.Username, loginReq.Password)
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to check login: %v", err))
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to check credentials",
+		})
+	}
+	if !ok {
+		if err := recordLoginFailure(c.IP(), loginReq.Username); err != nil {
+			config.LogMessage("ERROR", fmt.Sprintf("Failed to record login attempt: %v", err))
+		}
 		return c.Status(401).JSON(fiber.Map{
 			"error": "Invalid username or password",
 		})
 	}
 
-	// Generate JWT token
-	token, err := middleware.GenerateToken(loginReq.Username)
+	// Users with two-factor authentication get a challenge to send back with their code instead of a session
+	if user.TwoFactorEnabled {
+		challenge, err := middleware.GenerateChallengeToken(user.ID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Failed to generate token",
+			})
+		}
+		return c.JSON(fiber.Map{
+			"two_factor_required": true,
+			"challenge":           challenge,
+			"message":             "Enter the code from your authenticator app",
+		})
+	}
+
+	return sendNewSession(c, user)
+}
+
+// sendNewSession signs the user in once they have proved who they are, responding with the tokens for their new session
+func sendNewSession(c *fiber.Ctx, user models.User) error {
+	if err := clearLoginFailures(c.IP(), user.Username); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to clear login attempts: %v", err))
+	}
+
+	tokens, err := middleware.StartSession(c, user)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Failed to generate token",
 		})
 	}
 
+	// Browsers use the cookies; scripts take the tokens from the body and send them as a bearer token instead
+	middleware.SetSessionCookies(c, tokens)
 	return c.JSON(fiber.Map{
-		"token":   token,
-		"message": "Login successful",
+		"token":                tokens.AccessToken,
+		"refresh_token":        tokens.RefreshToken,
+		"expires_in":           tokens.ExpiresIn,
+		"csrf_token":           tokens.CSRFToken,
+		"message":              "Login successful",
+		"must_change_password": user.MustChangePassword,
 	})
 }
 
+// RefreshToken trades a refresh token for a new access token and the refresh token to use next time
+func RefreshToken(c *fiber.Ctx) error {
+	var req RefreshRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+	if req.RefreshToken == "" {
+		req.RefreshToken = middleware.RefreshTokenCookie(c)
+	}
+	if req.RefreshToken == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "No refresh token",
+		})
+	}
+
+	sessionID, userID, refreshToken, err := middleware.RotateRefreshToken(c, req.RefreshToken)
+	if err == middleware.ErrInvalidRefreshToken {
+		middleware.ClearSessionCookies(c)
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Session has ended, please sign in again",
+		})
+	}
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to refresh session: %v", err))
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to refresh session",
+		})
+	}
+
+	// The token is made from the account as it is now, so role changes apply from the next refresh
+	user, err := scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", userID))
+	if err != nil {
+		middleware.RevokeSession(userID, sessionID)
+		middleware.ClearSessionCookies(c)
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Session has ended, please sign in again",
+		})
+	}
+
+	accessToken, err := middleware.GenerateToken(user, sessionID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to generate token",
+		})
+	}
+
+	tokens := middleware.SessionTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(middleware.AccessTokenLifetime.Seconds()),
+		CSRFToken:    middleware.CSRFToken(sessionID),
+	}
+	middleware.SetSessionCookies(c, tokens)
+	return c.JSON(tokens)
+}
+
 // CheckAuth verifies if the user is authenticated
 func CheckAuth(c *fiber.Ctx) error {
+	identity, _ := middleware.CurrentUser(c)
+	return c.JSON(fiber.Map{
+		"authenticated":        true,
+		"message":              "User is authenticated",
+		"username":             identity.Username,
+		"role":                 identity.Role,
+		"must_change_password": identity.MustChangePassword,
+	})
+}
+
+// ChangePassword sets a new password for the signed in user, who has to confirm their current one,
+// and signs them out everywhere else
+func ChangePassword(c *fiber.Ctx) error {
+	var req ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	user, ok, err := authenticateUser(middleware.CurrentUsername(c), req.CurrentPassword)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to check credentials",
+		})
+	}
+	if !ok {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "Current password is incorrect",
+		})
+	}
+
+	if err := validateNewPassword(req.NewPassword); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if req.NewPassword == req.CurrentPassword {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "New password must be different from the current one",
+		})
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to hash password",
+		})
+	}
+	if err := setUserPassword(user.ID, hash, false); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Anyone else signed in with the old password is signed out
+	identity, _ := middleware.CurrentUser(c)
+	if _, err := middleware.RevokeUserSessions(user.ID, identity.SessionID); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to end other sessions of user %s: %v", user.Username, err))
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("User %s changed their password", user.Username))
+	if !identity.MustChangePassword {
+		return c.JSON(fiber.Map{
+			"message": "Password changed successfully",
+		})
+	}
+
+	// The session's access token only let them change their password, so it is swapped for one that does not
+	user.MustChangePassword = false
+	accessToken, err := middleware.GenerateToken(user, identity.SessionID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to generate token",
+		})
+	}
+	middleware.SetAccessTokenCookie(c, accessToken)
+	return c.JSON(fiber.Map{
+		"message":    "Password changed successfully",
+		"token":      accessToken,
+		"expires_in": int(middleware.AccessTokenLifetime.Seconds()),
+	})
+}
+
+// Logout ends the session the request was made with, so its token stops working straight away
+func Logout(c *fiber.Ctx) error {
+	identity, _ := middleware.CurrentUser(c)
+	if _, err := middleware.RevokeSession(identity.UserID, identity.SessionID); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to end session",
+		})
+	}
+
+	middleware.ClearSessionCookies(c)
 	return c.JSON(fiber.Map{
-		"authenticated": true,
-		"message":       "User is authenticated",
+		"message": "Logout successful",
 	})
 }
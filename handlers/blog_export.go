@@ -0,0 +1,674 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/middleware"
+	"PersonalWebsiteGO/models"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Layout of an export bundle
+const (
+	exportPostsDir      = "posts/"
+	exportMediaDir      = "media/"
+	exportMediaManifest = "media.yaml"
+)
+
+const (
+	// maxImportFiles caps how many entries an import bundle may contain
+	maxImportFiles = 5000
+	// maxImportSize caps the total uncompressed size of an import bundle
+	maxImportSize = 256 << 20
+)
+
+// MaxImportRequestSize is the largest upload ImportBlogs accepts: a bundle of up to maxImportSize, which
+// compression only makes smaller, with room for the rest of the form
+const MaxImportRequestSize = maxImportSize + 1<<20
+
+// frontMatterDelimiter opens and closes the YAML block at the top of an exported post
+const frontMatterDelimiter = "---"
+
+// blogFrontMatter is the metadata written above each exported post's content
+type blogFrontMatter struct {
+	Title         string     `yaml:"title"`
+	Slug          string     `yaml:"slug"`
+	Author        string     `yaml:"author"`
+	Status        string     `yaml:"status"`
+	ContentFormat string     `yaml:"content_format"`
+	Category      string     `yaml:"category,omitempty"`
+	Series        string     `yaml:"series,omitempty"`
+	SeriesOrder   int        `yaml:"series_order,omitempty"`
+	Tags          []string   `yaml:"tags"`
+	Description   string     `yaml:"description,omitempty"`
+	CanonicalURL  string     `yaml:"canonical_url,omitempty"`
+	PublishAt     *time.Time `yaml:"publish_at,omitempty"`
+	CreatedAt     time.Time  `yaml:"created_at"`
+	UpdatedAt     time.Time  `yaml:"updated_at"`
+}
+
+// exportedMedia describes an upload in the bundle's media manifest, so its name and alt text survive a round trip
+type exportedMedia struct {
+	File     string `yaml:"file"`
+	FileName string `yaml:"file_name"`
+	AltText  string `yaml:"alt_text,omitempty"`
+}
+
+// importItem reports what an import did, or would do, with one file in the bundle
+type importItem struct {
+	File    string   `json:"file"`
+	Slug    string   `json:"slug,omitempty"`
+	Action  string   `json:"action"`
+	Changes []string `json:"changes,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// What an import does with each file in the bundle
+const (
+	importCreate    = "create"
+	importUpdate    = "update"
+	importUnchanged = "unchanged"
+	importError     = "error"
+)
+
+// importedPost is a post read from a bundle, matched against the post it would replace
+type importedPost struct {
+	item      *importItem
+	blog      models.Blog
+	existing  *models.Blog
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+// importedMedia is an upload in a bundle. Only one is read into memory at a time, so it is read again to be saved.
+type importedMedia struct {
+	item     *importItem
+	file     *zip.File
+	fileName string
+	altText  string
+}
+
+// marshalBlogMarkdown writes a post as a Markdown file with YAML front matter
+func marshalBlogMarkdown(blog models.Blog) ([]byte, error) {
+	frontMatter, err := yaml.Marshal(blogFrontMatter{
+		Title:         blog.Title,
+		Slug:          blog.Slug,
+		Author:        blog.Author,
+		Status:        blog.Status,
+		ContentFormat: blog.ContentFormat,
+		Category:      blog.Category,
+		Series:        blog.Series,
+		SeriesOrder:   blog.SeriesOrder,
+		Tags:          blog.Tags,
+		Description:   blog.MetaDescription,
+		CanonicalURL:  blog.CanonicalURL,
+		PublishAt:     blog.PublishAt,
+		CreatedAt:     blog.CreatedAt,
+		UpdatedAt:     blog.UpdatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(frontMatterDelimiter + "\n")
+	buf.Write(frontMatter)
+	buf.WriteString(frontMatterDelimiter + "\n\n")
+	buf.WriteString(blog.Content)
+	return buf.Bytes(), nil
+}
+
+// parseBlogMarkdown splits a Markdown file into its front matter and content
+func parseBlogMarkdown(data []byte) (blogFrontMatter, string, error) {
+	var frontMatter blogFrontMatter
+
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.TrimPrefix(text, "\ufeff")
+	if !strings.HasPrefix(text, frontMatterDelimiter+"\n") {
+		return frontMatter, "", fmt.Errorf("file does not start with front matter")
+	}
+
+	rest := text[len(frontMatterDelimiter)+1:]
+	end := strings.Index(rest, "\n"+frontMatterDelimiter+"\n")
+	if end < 0 {
+		return frontMatter, "", fmt.Errorf("front matter is not closed")
+	}
+
+	if err := yaml.Unmarshal([]byte(rest[:end]), &frontMatter); err != nil {
+		return frontMatter, "", fmt.Errorf("invalid front matter: %w", err)
+	}
+
+	content := rest[end+len(frontMatterDelimiter)+2:]
+	return frontMatter, strings.TrimPrefix(content, "\n"), nil
+}
+
+// referencedMedia lists the uploads used by any of the given posts
+func referencedMedia(blogs []models.Blog) ([]models.Media, error) {
+	rows, err := config.DB.Query("SELECT " + mediaColumns + " FROM media ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var used []models.Media
+	for rows.Next() {
+		media, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, blog := range blogs {
+			if strings.Contains(blog.Content, media.Hash) {
+				used = append(used, media)
+				break
+			}
+		}
+	}
+
+	return used, rows.Err()
+}
+
+// writeBlogExport writes every post and the media they use to a zip archive
+func writeBlogExport(w io.Writer, blogs []models.Blog, media []models.Media) error {
+	archive := zip.NewWriter(w)
+
+	for _, blog := range blogs {
+		data, err := marshalBlogMarkdown(blog)
+		if err != nil {
+			return fmt.Errorf("failed to export blog %d: %w", blog.ID, err)
+		}
+
+		name := blog.Slug
+		if name == "" {
+			name = fmt.Sprintf("post-%d", blog.ID)
+		}
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     exportPostsDir + name + ".md",
+			Method:   zip.Deflate,
+			Modified: blog.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(data); err != nil {
+			return err
+		}
+	}
+
+	manifest := []exportedMedia{}
+	for _, item := range media {
+		name := mediaFileName(item, "")
+		file, err := os.Open(filepath.Join(MediaDir(), name))
+		if err != nil {
+			config.LogMessage("ERROR", fmt.Sprintf("Failed to export media %s: %v", name, err))
+			continue
+		}
+
+		// Images are already compressed, but deflating them costs little and keeps bundles that are mostly
+		// media within MaxImportRequestSize when they come back
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     exportMediaDir + name,
+			Method:   zip.Deflate,
+			Modified: item.CreatedAt,
+		})
+		if err == nil {
+			_, err = io.Copy(entry, file)
+		}
+		file.Close()
+		if err != nil {
+			return err
+		}
+
+		manifest = append(manifest, exportedMedia{File: exportMediaDir + name, FileName: item.FileName, AltText: item.AltText})
+	}
+
+	manifestData, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	entry, err := archive.Create(exportMediaManifest)
+	if err != nil {
+		return err
+	}
+	if _, err := entry.Write(manifestData); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// ExportBlogs streams a zip of every post as Markdown with YAML front matter, along with the media they use
+func ExportBlogs(c *fiber.Ctx) error {
+	blogs, err := queryBlogs("ORDER BY id")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	media, err := referencedMedia(blogs)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="blog-export-%s.zip"`, time.Now().Format("2006-01-02")))
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := writeBlogExport(w, blogs, media); err != nil {
+			config.LogMessage("ERROR", fmt.Sprintf("Failed to export blogs: %v", err))
+		}
+	})
+
+	return nil
+}
+
+// errImportTooLarge is returned once an import bundle has expanded beyond maxImportSize
+var errImportTooLarge = fmt.Errorf("Bundles may expand to at most %d MB", maxImportSize>>20)
+
+// readZipFile reads one entry of an import bundle, refusing entries that expand beyond the upload limit.
+// remaining is the bundle's unread size budget and is reduced by what was read, since the sizes recorded in
+// the archive can't be trusted.
+func readZipFile(file *zip.File, remaining *int64) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	limit := min(int64(maxUploadSize), *remaining)
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	*remaining -= int64(len(data))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		if limit < maxUploadSize {
+			return nil, errImportTooLarge
+		}
+		return nil, fmt.Errorf("file is larger than %d MB", maxUploadSize>>20)
+	}
+	return data, nil
+}
+
+// importedMediaAction works out whether an image from a bundle is new to the library
+func importedMediaAction(media models.Media) (string, error) {
+	var exists int
+	err := config.DB.QueryRow("SELECT 1 FROM media WHERE hash = ?", media.Hash).Scan(&exists)
+	switch {
+	case err == sql.ErrNoRows:
+		return importCreate, nil
+	case err != nil:
+		return "", err
+	}
+	return importUnchanged, nil
+}
+
+// blogChanges lists the fields an imported post would change on the existing one
+func blogChanges(existing models.Blog, imported models.Blog) []string {
+	var changes []string
+	if existing.Title != imported.Title {
+		changes = append(changes, "title")
+	}
+	if existing.Content != imported.Content {
+		changes = append(changes, "content")
+	}
+	if existing.ContentFormat != imported.ContentFormat {
+		changes = append(changes, "content_format")
+	}
+	if existing.Author != imported.Author {
+		changes = append(changes, "author")
+	}
+	if existing.Status != imported.Status {
+		changes = append(changes, "status")
+	}
+	if (existing.PublishAt == nil) != (imported.PublishAt == nil) ||
+		(existing.PublishAt != nil && !existing.PublishAt.Equal(*imported.PublishAt)) {
+		changes = append(changes, "publish_at")
+	}
+	if existing.Category != imported.Category {
+		changes = append(changes, "category")
+	}
+	if existing.Series != imported.Series {
+		changes = append(changes, "series")
+	}
+	// Posts imported without a position keep the one they have
+	if imported.SeriesOrder != 0 && existing.SeriesOrder != imported.SeriesOrder {
+		changes = append(changes, "series_order")
+	}
+	if existing.MetaDescription != imported.MetaDescription {
+		changes = append(changes, "meta_description")
+	}
+	if existing.CanonicalURL != imported.CanonicalURL {
+		changes = append(changes, "canonical_url")
+	}
+
+	existingTags := slices.Sorted(slices.Values(existing.Tags))
+	importedTags := slices.Sorted(slices.Values(imported.Tags))
+	if !slices.Equal(existingTags, importedTags) {
+		changes = append(changes, "tags")
+	}
+
+	return changes
+}
+
+// prepareImportedPost validates a post read from a bundle and works out whether it is new, changed or unchanged
+func prepareImportedPost(data []byte, now time.Time) (importedPost, error) {
+	frontMatter, content, err := parseBlogMarkdown(data)
+	if err != nil {
+		return importedPost{}, err
+	}
+
+	blog := models.Blog{
+		Title:           frontMatter.Title,
+		Slug:            frontMatter.Slug,
+		Content:         content,
+		ContentFormat:   frontMatter.ContentFormat,
+		Author:          frontMatter.Author,
+		Status:          frontMatter.Status,
+		PublishAt:       frontMatter.PublishAt,
+		Category:        frontMatter.Category,
+		Series:          frontMatter.Series,
+		SeriesOrder:     frontMatter.SeriesOrder,
+		Tags:            frontMatter.Tags,
+		MetaDescription: frontMatter.Description,
+		CanonicalURL:    frontMatter.CanonicalURL,
+	}
+	if blog.Tags == nil {
+		blog.Tags = []string{}
+	}
+	// A post scheduled in an older bundle may have gone live since it was exported
+	if blog.Status == models.BlogStatusScheduled && blog.PublishAt != nil && !blog.PublishAt.After(now) {
+		blog.Status = models.BlogStatusPublished
+	}
+
+	if err := prepareBlog(&blog, now); err != nil {
+		return importedPost{}, err
+	}
+
+	if blog.Slug == "" {
+		blog.Slug = Slugify(blog.Title)
+	}
+	if !IsValidSlug(blog.Slug) {
+		return importedPost{}, errInvalidSlug
+	}
+
+	post := importedPost{blog: blog, createdAt: frontMatter.CreatedAt, updatedAt: frontMatter.UpdatedAt}
+	if post.createdAt.IsZero() {
+		post.createdAt = now
+	}
+	if post.updatedAt.IsZero() {
+		post.updatedAt = post.createdAt
+	}
+
+	existing, err := scanBlog(config.DB.QueryRow("SELECT "+blogColumns+" FROM blogs WHERE slug = ?", blog.Slug))
+	if err == nil {
+		post.existing = &existing
+	} else if err != sql.ErrNoRows {
+		return importedPost{}, err
+	}
+
+	return post, nil
+}
+
+// applyImportedPost creates or updates the post read from a bundle
+func applyImportedPost(tx *sql.Tx, c *fiber.Ctx, post importedPost, now time.Time) error {
+	blog := post.blog
+
+	existingID := 0
+	if post.existing != nil {
+		existingID = post.existing.ID
+	}
+	if err := assignSeriesOrder(tx, &blog, existingID); err != nil {
+		return err
+	}
+
+	if post.existing == nil {
+		result, err := tx.Exec(
+			"INSERT INTO blogs (title, slug, content, content_format, content_html, author, status, publish_at, category, series, series_order, meta_description, canonical_url, word_count, reading_time, toc, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			blog.Title, blog.Slug, blog.Content, blog.ContentFormat, string(blog.ContentHTML), blog.Author, blog.Status, blog.PublishAt, blog.Category, blog.Series, blog.SeriesOrder, blog.MetaDescription, blog.CanonicalURL,
+			blog.WordCount, blog.ReadingTime, marshalTOC(blog.TableOfContents), post.createdAt, post.updatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		id, _ := result.LastInsertId()
+		blog.ID = int(id)
+	} else {
+		blog.ID = post.existing.ID
+		_, err := tx.Exec(
+			"UPDATE blogs SET title = ?, content = ?, content_format = ?, content_html = ?, author = ?, status = ?, publish_at = ?, category = ?, series = ?, series_order = ?, meta_description = ?, canonical_url = ?, word_count = ?, reading_time = ?, toc = ?, updated_at = ? WHERE id = ?",
+			blog.Title, blog.Content, blog.ContentFormat, string(blog.ContentHTML), blog.Author, blog.Status, blog.PublishAt, blog.Category, blog.Series, blog.SeriesOrder, blog.MetaDescription, blog.CanonicalURL,
+			blog.WordCount, blog.ReadingTime, marshalTOC(blog.TableOfContents), now, blog.ID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := setBlogTags(tx, blog.ID, blog.Tags); err != nil {
+		return err
+	}
+
+	if err := indexBlog(tx, blog.ID, blog.Title, blog.ContentHTML); err != nil {
+		return err
+	}
+
+	return saveBlogRevision(tx, blog.ID, middleware.CurrentUsername(c), nil)
+}
+
+// applyImport saves the media and posts of a bundle that has been checked. Everything is saved in one
+// transaction, and the files of any new media are removed again if it fails, so a failed import changes nothing.
+func applyImport(c *fiber.Ctx, media []importedMedia, posts []importedPost, now time.Time) (err error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stored []models.Media
+	defer func() {
+		if err != nil {
+			for _, item := range stored {
+				removeMediaFiles(item)
+			}
+		}
+	}()
+
+	// Media goes first so imported posts never refer to files that are missing
+	for _, item := range media {
+		if item.item.Action != importCreate {
+			continue
+		}
+		budget := int64(maxUploadSize)
+		data, err := readZipFile(item.file, &budget)
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %w", item.item.File, err)
+		}
+		saved, created, err := saveMedia(tx, data, item.fileName, item.altText)
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %w", item.item.File, err)
+		}
+		if created {
+			stored = append(stored, saved)
+		}
+	}
+
+	for _, post := range posts {
+		if post.item.Action == importUnchanged {
+			continue
+		}
+		if err := applyImportedPost(tx, c, post, now); err != nil {
+			return fmt.Errorf("failed to import %s: %w", post.item.File, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// The search index changed inside the transaction, after the related posts cache was last cleared
+	invalidateRelatedTerms()
+	return nil
+}
+
+// ImportBlogs creates or updates posts from a zip in the format ExportBlogs produces, uploaded as the multipart
+// field "file". Posts are matched to existing ones by slug. Nothing is changed if any file in the bundle is
+// invalid or fails to save, and ?dry_run=true reports what would be created or changed without saving anything.
+func ImportBlogs(c *fiber.Ctx) error {
+	dryRun := c.QueryBool("dry_run")
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "A zip file is required"})
+	}
+
+	// Large uploads are spooled to a temporary file, which the archive is read from directly
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Failed to read upload"})
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, fileHeader.Size)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "The file is not a valid zip archive"})
+	}
+	if len(archive.File) > maxImportFiles {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Bundles may contain at most %d files", maxImportFiles)})
+	}
+	var totalSize uint64
+	for _, zipFile := range archive.File {
+		totalSize += zipFile.UncompressedSize64
+	}
+	if totalSize > maxImportSize {
+		return c.Status(400).JSON(fiber.Map{"error": errImportTooLarge.Error()})
+	}
+	remaining := int64(maxImportSize)
+
+	now := normalizePublishTime(time.Now())
+	items := []*importItem{}
+	var posts []importedPost
+	var media []importedMedia
+	manifest := map[string]exportedMedia{}
+	slugs := map[string]string{}
+
+	for _, zipFile := range archive.File {
+		name := zipFile.Name
+		if zipFile.FileInfo().IsDir() {
+			continue
+		}
+
+		if name == exportMediaManifest {
+			var entries []exportedMedia
+			data, err := readZipFile(zipFile, &remaining)
+			if err == errImportTooLarge {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			if err == nil {
+				err = yaml.Unmarshal(data, &entries)
+			}
+			if err != nil {
+				items = append(items, &importItem{File: name, Action: importError, Error: err.Error()})
+				continue
+			}
+			for _, entry := range entries {
+				manifest[entry.File] = entry
+			}
+			continue
+		}
+
+		item := &importItem{File: name}
+		items = append(items, item)
+
+		data, err := readZipFile(zipFile, &remaining)
+		if err == errImportTooLarge {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			item.Action, item.Error = importError, err.Error()
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(name, exportMediaDir):
+			checked, err := checkMedia(data)
+			if err != nil {
+				item.Action, item.Error = importError, err.Error()
+				continue
+			}
+			item.Action, err = importedMediaAction(checked)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			media = append(media, importedMedia{item: item, file: zipFile, fileName: path.Base(name)})
+
+		case strings.EqualFold(path.Ext(name), ".md"):
+			post, err := prepareImportedPost(data, now)
+			if err != nil {
+				item.Action, item.Error = importError, err.Error()
+				continue
+			}
+
+			item.Slug = post.blog.Slug
+			if other, ok := slugs[post.blog.Slug]; ok {
+				item.Action, item.Error = importError, fmt.Sprintf("slug is also used by %s", other)
+				continue
+			}
+			slugs[post.blog.Slug] = name
+
+			post.item = item
+			if post.existing == nil {
+				item.Action = importCreate
+			} else if item.Changes = blogChanges(*post.existing, post.blog); len(item.Changes) > 0 {
+				item.Action = importUpdate
+			} else {
+				item.Action = importUnchanged
+			}
+			posts = append(posts, post)
+
+		default:
+			item.Action, item.Error = importError, "unrecognised file"
+		}
+	}
+
+	for i := range media {
+		item := &media[i]
+		if entry, ok := manifest[item.item.File]; ok {
+			item.fileName, item.altText = entry.FileName, entry.AltText
+		}
+	}
+
+	summary := map[string]int{importCreate: 0, importUpdate: 0, importUnchanged: 0, importError: 0}
+	for _, item := range items {
+		summary[item.Action]++
+	}
+	report := fiber.Map{"dry_run": dryRun, "summary": summary, "files": items}
+
+	if summary[importError] > 0 {
+		report["error"] = "The bundle contains invalid files, so nothing was imported"
+		return c.Status(400).JSON(report)
+	}
+	if dryRun {
+		return c.JSON(report)
+	}
+
+	if err := applyImport(c, media, posts, now); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to import blog bundle: %v", err))
+		return c.Status(mediaErrorStatus(err)).JSON(fiber.Map{"error": err.Error() + "; nothing was imported"})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("Imported blog bundle: %d created, %d updated, %d unchanged",
+		summary[importCreate], summary[importUpdate], summary[importUnchanged]))
+
+	return c.JSON(report)
+}
@@ -2,48 +2,193 @@ package handlers
 
 import (
 	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/middleware"
 	"PersonalWebsiteGO/models"
+	"database/sql"
 	"fmt"
 	"html/template"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// GetAllBlogs retrieves all blog posts
-func GetAllBlogs(c *fiber.Ctx) error {
-	rows, err := config.DB.Query("SELECT id, title, content, author, created_at, updated_at FROM blogs ORDER BY created_at DESC")
+const blogColumns = "id, title, slug, content, content_format, content_html, author, status, publish_at, category, " + blogTagsColumn + ", series, series_order, meta_description, canonical_url, word_count, reading_time, toc, created_at, updated_at"
+
+// blogVisibilityFilter limits anonymous readers to published posts, while signed in users can preview everything
+func blogVisibilityFilter(c *fiber.Ctx) string {
+	if middleware.IsAuthenticated(c) {
+		return "1 = 1"
+	}
+	return "status = '" + models.BlogStatusPublished + "'"
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx, for helpers that may run inside a transaction
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// rowScannerFunc adapts a function to rowScanner, for queries that select extra columns after a post's
+type rowScannerFunc func(dest ...any) error
+
+func (f rowScannerFunc) Scan(dest ...any) error {
+	return f(dest...)
+}
+
+func scanBlog(row rowScanner) (models.Blog, error) {
+	var blog models.Blog
+	var contentHTML string
+	var publishAt sql.NullTime
+	var tags sql.NullString
+	var toc string
+	err := row.Scan(&blog.ID, &blog.Title, &blog.Slug, &blog.Content, &blog.ContentFormat, &contentHTML, &blog.Author, &blog.Status, &publishAt, &blog.Category, &tags, &blog.Series, &blog.SeriesOrder,
+		&blog.MetaDescription, &blog.CanonicalURL, &blog.WordCount, &blog.ReadingTime, &toc, &blog.CreatedAt, &blog.UpdatedAt)
+	blog.ContentHTML = template.HTML(contentHTML)
+	if publishAt.Valid {
+		blog.PublishAt = &publishAt.Time
+	}
+	blog.Tags = splitTags(tags.String)
+	blog.TableOfContents = unmarshalTOC(toc)
+	return blog, err
+}
+
+// queryBlogs selects posts using the given WHERE/ORDER BY clause
+func queryBlogs(clause string, args ...any) ([]models.Blog, error) {
+	rows, err := config.DB.Query("SELECT "+blogColumns+" FROM blogs "+clause, args...)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		return nil, err
 	}
 	defer rows.Close()
 
 	var blogs []models.Blog
 	for rows.Next() {
-		var blog models.Blog
-		err := rows.Scan(&blog.ID, &blog.Title, &blog.Content, &blog.Author, &blog.CreatedAt, &blog.UpdatedAt)
+		blog, err := scanBlog(rows)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return nil, err
 		}
 		blogs = append(blogs, blog)
 	}
 
+	return blogs, rows.Err()
+}
+
+// prepareBlogTaxonomy normalizes the post's category and tags
+func prepareBlogTaxonomy(blog *models.Blog) error {
+	blog.Category = NormalizeTaxonomyName(blog.Category)
+
+	if blog.Tags == nil {
+		return nil
+	}
+	tags, err := normalizeTags(blog.Tags)
+	if err != nil {
+		return invalidField("tags", "%v", err)
+	}
+	blog.Tags = tags
+	return nil
+}
+
+// prepareBlogContent validates the post's content format, renders its HTML and works out its outline.
+// New posts without a format are written in Markdown.
+func prepareBlogContent(blog *models.Blog) error {
+	if blog.ContentFormat == "" {
+		blog.ContentFormat = models.ContentFormatMarkdown
+	}
+	if !IsValidContentFormat(blog.ContentFormat) {
+		return invalidField("content_format", "content_format must be %q or %q", models.ContentFormatMarkdown, models.ContentFormatHTML)
+	}
+
+	rendered, err := RenderBlogContent(blog.Content, blog.ContentFormat)
+	if err != nil {
+		return invalidField("content", "%v", err)
+	}
+	blog.ContentHTML = rendered
+	applyBlogOutline(blog)
+	return nil
+}
+
+// GetAllBlogs retrieves a page of the blog posts visible to the caller, optionally filtered by ?tag= and ?category=.
+// Pages are chosen with ?limit= and ?cursor=, ordered by ?sort=, and linked through the Link header.
+func GetAllBlogs(c *fiber.Ctx) error {
+	sort, ok := findBlogSort(c.Query("sort"))
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid sort"})
+	}
+
+	limit := c.QueryInt("limit", defaultPageSize)
+	if limit < 1 || limit > maxPageSize {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+	}
+
+	var cursor *blogCursor
+	if encoded := c.Query("cursor"); encoded != "" {
+		decoded, err := decodeCursor(encoded, sort)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid cursor"})
+		}
+		cursor = &decoded
+	}
+
+	where, args := blogListFilter(c, c.Query("tag"), c.Query("category"))
+	total, err := countBlogs(where, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	blogs, next, err := queryBlogPage(where, args, sort, cursor, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, c.BaseURL()+pageURL(c, map[string]string{"cursor": ""}))}
+	if next != nil {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, c.BaseURL()+pageURL(c, map[string]string{"cursor": encodeCursor(*next)})))
+	}
+	c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+	c.Set("X-Total-Count", strconv.Itoa(total))
+
 	return c.JSON(blogs)
 }
 
-// GetBlogByID retrieves a single blog post by ID
+// blogDetail adds the post's series navigation and related posts, leaving them out if they cannot be worked out
+func blogDetail(c *fiber.Ctx, blog models.Blog) models.BlogDetail {
+	detail := models.BlogDetail{Blog: blog, Related: []models.BlogLink{}}
+
+	navigation, err := getSeriesNavigation(c, blog)
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to load series for blog %d: %v", blog.ID, err))
+	}
+	detail.SeriesNavigation = navigation
+
+	related, err := getRelatedPosts(c, blog)
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to find posts related to blog %d: %v", blog.ID, err))
+	} else {
+		detail.Related = related
+	}
+
+	return detail
+}
+
+// GetBlogByID retrieves a single blog post by ID, along with its series navigation and related posts.
+// The ETag header identifies the version of the post, for use in If-Match when changing it.
 func GetBlogByID(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	var blog models.Blog
-	err := config.DB.QueryRow("SELECT id, title, content, author, created_at, updated_at FROM blogs WHERE id = ?", id).
-		Scan(&blog.ID, &blog.Title, &blog.Content, &blog.Author, &blog.CreatedAt, &blog.UpdatedAt)
-
+	blog, err := scanBlog(config.DB.QueryRow("SELECT "+blogColumns+" FROM blogs WHERE id = ? AND "+blogVisibilityFilter(c), id))
 	if err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Blog not found"})
 	}
 
-	return c.JSON(blog)
+	setBlogETag(c, blog.ID)
+	return c.JSON(blogDetail(c, blog))
 }
 
 // CreateBlog creates a new blog post
@@ -53,10 +198,27 @@ func CreateBlog(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
+	if blog.Tags == nil {
+		blog.Tags = []string{}
+	}
+
 	now := time.Now()
+	if err := prepareBlog(&blog, now); err != nil {
+		return sendBlogError(c, err)
+	}
+
+	if err := assignBlogSlug(&blog, 0); err != nil {
+		return sendBlogError(c, err)
+	}
+
+	if err := assignSeriesOrder(config.DB, &blog, 0); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
 	result, err := config.DB.Exec(
-		"INSERT INTO blogs (title, content, author, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
-		blog.Title, blog.Content, blog.Author, now, now,
+		"INSERT INTO blogs (title, slug, content, content_format, content_html, author, status, publish_at, category, series, series_order, meta_description, canonical_url, word_count, reading_time, toc, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		blog.Title, blog.Slug, blog.Content, blog.ContentFormat, string(blog.ContentHTML), blog.Author, blog.Status, blog.PublishAt, blog.Category, blog.Series, blog.SeriesOrder, blog.MetaDescription, blog.CanonicalURL,
+		blog.WordCount, blog.ReadingTime, marshalTOC(blog.TableOfContents), now, now,
 	)
 
 	if err != nil {
@@ -65,42 +227,147 @@ func CreateBlog(c *fiber.Ctx) error {
 
 	id, _ := result.LastInsertId()
 	blog.ID = int(id)
+
+	if err := setBlogTags(config.DB, blog.ID, blog.Tags); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := indexBlog(config.DB, blog.ID, blog.Title, blog.ContentHTML); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to index blog %d for search: %v", blog.ID, err))
+	}
+
+	if err := saveBlogRevision(config.DB, blog.ID, middleware.CurrentUsername(c), nil); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to save revision for blog %d: %v", blog.ID, err))
+	}
 	blog.CreatedAt = now
 	blog.UpdatedAt = now
 
+	setBlogETag(c, blog.ID)
 	return c.Status(201).JSON(blog)
 }
 
-// UpdateBlog updates an existing blog post
-func UpdateBlog(c *fiber.Ctx) error {
-	id := c.Params("id")
-
-	var blog models.Blog
-	if err := c.BodyParser(&blog); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+// saveBlogUpdate validates an edited post and saves it over the version it was edited from, failing with
+// errBlogChanged if the post has been saved again in the meantime
+func saveBlogUpdate(c *fiber.Ctx, id int, previous models.Blog, version blogVersion, blog *models.Blog) error {
+	// Leaving the format out keeps the one the post was written in rather than reverting to Markdown
+	if blog.ContentFormat == "" {
+		blog.ContentFormat = previous.ContentFormat
 	}
 
 	now := time.Now()
+	if err := prepareBlog(blog, now); err != nil {
+		return err
+	}
+
+	if err := assignBlogSlug(blog, id); err != nil {
+		return err
+	}
+
+	if err := assignSeriesOrder(config.DB, blog, id); err != nil {
+		return err
+	}
+
 	result, err := config.DB.Exec(
-		"UPDATE blogs SET title = ?, content = ?, author = ?, updated_at = ? WHERE id = ?",
-		blog.Title, blog.Content, blog.Author, now, id,
+		"UPDATE blogs SET title = ?, slug = ?, content = ?, content_format = ?, content_html = ?, author = ?, status = ?, publish_at = ?, category = ?, series = ?, series_order = ?, meta_description = ?, canonical_url = ?, word_count = ?, reading_time = ?, toc = ?, updated_at = ? WHERE id = ? AND updated_at = ?",
+		blog.Title, blog.Slug, blog.Content, blog.ContentFormat, string(blog.ContentHTML), blog.Author, blog.Status, blog.PublishAt, blog.Category, blog.Series, blog.SeriesOrder, blog.MetaDescription, blog.CanonicalURL,
+		blog.WordCount, blog.ReadingTime, marshalTOC(blog.TableOfContents), now, id, version.updatedAt,
 	)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return errBlogChanged
+	}
+
+	if blog.Tags != nil {
+		if err := setBlogTags(config.DB, id, blog.Tags); err != nil {
+			return err
+		}
+	}
+
+	if err := indexBlog(config.DB, id, blog.Title, blog.ContentHTML); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to index blog %d for search: %v", id, err))
+	}
 
+	if err := saveBlogRevision(config.DB, id, middleware.CurrentUsername(c), nil); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to save revision for blog %d: %v", id, err))
+	}
+
+	if err := recordSlugChange(id, previous.Slug, blog.Slug); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to record slug redirect for blog %d: %v", id, err))
+	}
+
+	return nil
+}
+
+// UpdateBlog saves an edited blog post. Every field the request sends is replaced, even with an empty value,
+// and every field it leaves out is kept. Sending the post's ETag in If-Match makes the update fail with
+// 412 Precondition Failed if someone else has saved the post since it was loaded.
+func UpdateBlog(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid blog ID"})
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	previous, err := scanBlog(config.DB.QueryRow("SELECT "+blogColumns+" FROM blogs WHERE id = ?", id))
+	if err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Blog not found"})
 	}
 
+	version, err := getBlogVersion(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ifMatch(c, version) {
+		return sendBlogError(c, errBlogChanged)
+	}
+
+	// The request is read over the post as it is, so every field it leaves out keeps its value. That keeps
+	// links stable when the slug is left out, and the publish date when the status is changed on its own.
+	blog := previous
+	if err := c.BodyParser(&blog); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if err := saveBlogUpdate(c, id, previous, version, &blog); err != nil {
+		return sendBlogError(c, err)
+	}
+
+	setBlogETag(c, id)
 	return c.JSON(fiber.Map{"message": "Blog updated successfully"})
 }
 
+// PreviewBlog renders post content without saving it so the editor can show what visitors will see
+func PreviewBlog(c *fiber.Ctx) error {
+	var blog models.Blog
+	if err := c.BodyParser(&blog); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if err := prepareBlogContent(&blog); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"content_html": blog.ContentHTML})
+}
+
 // DeleteBlog deletes a blog post
 func DeleteBlog(c *fiber.Ctx) error {
-	id := c.Params("id")
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid blog ID"})
+	}
+
+	version, err := getBlogVersion(id)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Blog not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ifMatch(c, version) {
+		return sendBlogError(c, errBlogChanged)
+	}
 
 	result, err := config.DB.Exec("DELETE FROM blogs WHERE id = ?", id)
 	if err != nil {
@@ -112,12 +379,99 @@ func DeleteBlog(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "Blog not found"})
 	}
 
+	if _, err := config.DB.Exec("DELETE FROM blog_slug_redirects WHERE blog_id = ?", id); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to remove slug redirects for blog %d: %v", id, err))
+	}
+
+	if _, err := config.DB.Exec("DELETE FROM blog_tags WHERE blog_id = ?", id); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to remove tags for blog %d: %v", id, err))
+	}
+
+	if _, err := config.DB.Exec("DELETE FROM blog_revisions WHERE blog_id = ?", id); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to remove revisions for blog %d: %v", id, err))
+	}
+
+	if _, err := config.DB.Exec("DELETE FROM comments WHERE blog_id = ?", id); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to remove comments for blog %d: %v", id, err))
+	}
+
+	if _, err := config.DB.Exec("DELETE FROM webmentions WHERE blog_id = ?", id); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to remove webmentions for blog %d: %v", id, err))
+	}
+
+	if err := unindexBlog(config.DB, id); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to remove blog %d from search: %v", id, err))
+	}
+
+	removeBlogPreviewImages(id, "")
+
 	return c.JSON(fiber.Map{"message": "Blog deleted successfully"})
 }
 
-// RenderBlogsPage renders the blogs page with all blog posts
+// RenderBlogPostPage renders a single blog post by its slug, permanently redirecting slugs the post used to have
+func RenderBlogPostPage(c *fiber.Ctx) error {
+	slug := c.Params("slug")
+
+	blog, err := scanBlog(config.DB.QueryRow("SELECT "+blogColumns+" FROM blogs WHERE slug = ? AND "+blogVisibilityFilter(c), slug))
+	if err == sql.ErrNoRows {
+		var currentSlug string
+		err := config.DB.QueryRow(
+			"SELECT b.slug FROM blog_slug_redirects r JOIN blogs b ON b.id = r.blog_id WHERE r.old_slug = ?", slug,
+		).Scan(&currentSlug)
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+		return c.Redirect("/blog/"+currentSlug, fiber.StatusMovedPermanently)
+	}
+	if err != nil {
+		fmt.Println("Error fetching blog:", err)
+		return fiber.ErrInternalServerError
+	}
+
+	comments, err := getApprovedComments(blog.ID)
+	if err != nil {
+		fmt.Println("Error fetching comments:", err)
+	}
+
+	mentions, err := getVerifiedWebmentions(blog.ID)
+	if err != nil {
+		fmt.Println("Error fetching webmentions:", err)
+	}
+
+	// Advertise where to send webmentions about this post
+	c.Set(fiber.HeaderLink, fmt.Sprintf(`<%s/webmention>; rel="webmention"`, siteURL()))
+
+	return c.Render("blog/post", fiber.Map{
+		"Title":       blog.Title,
+		"Meta":        blogPageMeta(blog),
+		"Blog":        blogDetail(c, blog),
+		"Comments":    comments,
+		"Webmentions": mentions,
+	}, "layout/base")
+}
+
+// RenderBlogsPage renders the blogs page with all blog posts visible to the caller, optionally filtered by ?tag= and ?category=
 func RenderBlogsPage(c *fiber.Ctx) error {
-	rows, err := config.DB.Query("SELECT id, title, content, author, created_at, updated_at FROM blogs ORDER BY created_at DESC")
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		return renderBlogSearchResults(c, q)
+	}
+
+	tag := NormalizeTaxonomyName(c.Query("tag"))
+	category := NormalizeTaxonomyName(c.Query("category"))
+	sort, _ := findBlogSort(c.Query("sort"))
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	where, args := blogListFilter(c, tag, category)
+	total, err := countBlogs(where, args...)
+	if err != nil {
+		fmt.Println("Error counting blogs:", err)
+	}
+	totalPages := (total + defaultPageSize - 1) / defaultPageSize
+
+	blogs, err := queryBlogs("WHERE "+where+" "+sort.orderBy()+" LIMIT ? OFFSET ?", append(args, defaultPageSize, (page-1)*defaultPageSize)...)
 	if err != nil {
 		fmt.Println("Error fetching blogs:", err)
 		return c.Render("projects/blogs", fiber.Map{
@@ -126,23 +480,53 @@ func RenderBlogsPage(c *fiber.Ctx) error {
 			"Error": "Failed to load blogs",
 		}, "layout/base")
 	}
-	defer rows.Close()
 
-	var blogs []models.Blog
-	for rows.Next() {
-		var blog models.Blog
-		err := rows.Scan(&blog.ID, &blog.Title, &blog.Content, &blog.Author, &blog.CreatedAt, &blog.UpdatedAt)
-		if err != nil {
-			fmt.Println("Error scanning blog:", err)
-			continue
-		}
-		// Convert content to template.HTML for safe rendering
-		blog.Content = template.HTML(blog.Content)
-		blogs = append(blogs, blog)
+	tags, err := getTagCounts(c)
+	if err != nil {
+		fmt.Println("Error fetching tag counts:", err)
 	}
 
-	return c.Render("projects/blogs", fiber.Map{
-		"Title": "Blogs",
-		"Blogs": blogs,
-	}, "layout/base")
+	data := fiber.Map{
+		"Title":    "Blogs",
+		"Blogs":    blogs,
+		"Tags":     tags,
+		"Tag":      tag,
+		"Category": category,
+		"Sorts":    sortLinks(c, sort),
+		"Pages":    pageLinks(c, page, totalPages),
+	}
+	if page == 2 {
+		data["PrevURL"] = pageURL(c, map[string]string{"page": ""})
+	} else if page > 2 {
+		data["PrevURL"] = pageURL(c, map[string]string{"page": strconv.Itoa(page - 1)})
+	}
+	if page < totalPages {
+		data["NextURL"] = pageURL(c, map[string]string{"page": strconv.Itoa(page + 1)})
+	}
+
+	return c.Render("projects/blogs", data, "layout/base")
+}
+
+// renderBlogSearchResults renders the blogs page with the posts matching a search instead of every post
+func renderBlogSearchResults(c *fiber.Ctx, q string) error {
+	data := fiber.Map{
+		"Title": "Search: " + q,
+		"Query": q,
+	}
+
+	if len(q) > maxSearchLength {
+		data["Error"] = "Search query is too long"
+		return c.Render("projects/blogs", data, "layout/base")
+	}
+
+	results, err := searchBlogs(c, q, maxSearchLimit)
+	if err == errEmptySearch {
+		data["Error"] = "Search for at least one word"
+	} else if err != nil {
+		fmt.Println("Error searching blogs:", err)
+		data["Error"] = "Failed to search blogs"
+	}
+	data["Results"] = results
+
+	return c.Render("projects/blogs", data, "layout/base")
 }
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/models"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// wordsPerMinute is the reading speed reading times are estimated with
+const wordsPerMinute = 200
+
+// headingLevels maps heading elements to their level
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+// reservedHeadingIDs are ids the post page already uses outside the post's content, which headings must not take.
+// The page's other ids are camel case, which heading ids never are.
+var reservedHeadingIDs = []string{"comments", "webmentions", "stylesheet"}
+
+// addHeadingAnchors gives every heading in rendered post HTML an id derived from its text so sections can be
+// linked to, returning the rewritten HTML and the headings in document order. Ids only change when the
+// heading text does, and ids already taken are numbered in the order they appear until one is free.
+func addHeadingAnchors(contentHTML string) (string, []models.TOCEntry) {
+	var out bytes.Buffer
+	var headings []models.TOCEntry
+	used := map[string]bool{}
+	for _, id := range reservedHeadingIDs {
+		used[id] = true
+	}
+
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(contentHTML))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == xhtml.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				// The HTML has already been sanitized, so this should not happen; leave it untouched if it does
+				return contentHTML, nil
+			}
+			break
+		}
+
+		token := tokenizer.Token()
+		level, isHeading := headingLevels[token.DataAtom]
+		if tokenType != xhtml.StartTagToken || !isHeading {
+			out.Write(tokenizer.Raw())
+			continue
+		}
+
+		// Buffer the heading's contents to work out its text before writing its opening tag
+		var inner bytes.Buffer
+		var text strings.Builder
+		for {
+			innerType := tokenizer.Next()
+			if innerType == xhtml.ErrorToken {
+				break
+			}
+			if innerType == xhtml.EndTagToken && tokenizer.Token().DataAtom == token.DataAtom {
+				break
+			}
+			raw := tokenizer.Raw()
+			if innerType == xhtml.TextToken {
+				text.WriteString(html.UnescapeString(string(raw)))
+			}
+			inner.Write(raw)
+		}
+
+		headingText := strings.Join(strings.Fields(text.String()), " ")
+		id := "section"
+		if slug := Slugify(headingText); headingText != "" && slug != "" {
+			id = slug
+		}
+		// "Step", "Step" and "Step 2" would otherwise both end up as step-2
+		base := id
+		for n := 2; used[id]; n++ {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		used[id] = true
+
+		attrs := token.Attr[:0]
+		for _, attr := range token.Attr {
+			if attr.Key != "id" {
+				attrs = append(attrs, attr)
+			}
+		}
+		token.Attr = append(attrs, xhtml.Attribute{Key: "id", Val: id})
+
+		out.WriteString(token.String())
+		out.Write(inner.Bytes())
+		out.WriteString("</" + token.Data + ">")
+
+		headings = append(headings, models.TOCEntry{Level: level, ID: id, Text: headingText})
+	}
+
+	return out.String(), headings
+}
+
+// nestTOC arranges headings into a tree, each holding the lower level headings that follow it
+func nestTOC(headings []models.TOCEntry) []models.TOCEntry {
+	nested := []models.TOCEntry{}
+	for i := 0; i < len(headings); {
+		entry := headings[i]
+		end := i + 1
+		for end < len(headings) && headings[end].Level > entry.Level {
+			end++
+		}
+		entry.Children = nestTOC(headings[i+1 : end])
+		nested = append(nested, entry)
+		i = end
+	}
+	return nested
+}
+
+// outlineBlog works out a rendered post's word count, reading time in minutes and table of contents
+func outlineBlog(contentHTML template.HTML) (int, int, []models.TOCEntry) {
+	words := len(strings.Fields(plainText(string(contentHTML))))
+	readingTime := 0
+	if words > 0 {
+		readingTime = max(1, (words+wordsPerMinute-1)/wordsPerMinute)
+	}
+
+	_, headings := addHeadingAnchors(string(contentHTML))
+	return words, readingTime, nestTOC(headings)
+}
+
+// applyBlogOutline fills in a post's word count, reading time and table of contents from its rendered HTML
+func applyBlogOutline(blog *models.Blog) {
+	blog.WordCount, blog.ReadingTime, blog.TableOfContents = outlineBlog(blog.ContentHTML)
+}
+
+// marshalTOC encodes a table of contents for the toc column
+func marshalTOC(toc []models.TOCEntry) string {
+	if toc == nil {
+		return "[]"
+	}
+	data, err := json.Marshal(toc)
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
+
+// unmarshalTOC decodes the toc column
+func unmarshalTOC(data string) []models.TOCEntry {
+	toc := []models.TOCEntry{}
+	if data != "" {
+		json.Unmarshal([]byte(data), &toc)
+	}
+	return toc
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 50
+)
+
+// blogSort is a listing order. Each has a matching index in config, and ties are broken by id so
+// cursors always point at a single position.
+type blogSort struct {
+	Name  string
+	Label string
+	key   string
+	desc  bool
+}
+
+var blogSorts = []blogSort{
+	{Name: "newest", Label: "Newest", key: "COALESCE(publish_at, created_at)", desc: true},
+	{Name: "oldest", Label: "Oldest", key: "COALESCE(publish_at, created_at)", desc: false},
+	{Name: "updated", Label: "Recently updated", key: "updated_at", desc: true},
+	{Name: "title", Label: "Title", key: "title COLLATE NOCASE", desc: false},
+}
+
+// blogOrder lists posts newest first by the date readers see them posted
+var blogOrder = blogSorts[0].orderBy()
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func (s blogSort) direction() string {
+	if s.desc {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+func (s blogSort) orderBy() string {
+	return fmt.Sprintf("ORDER BY %s %s, id %s", s.key, s.direction(), s.direction())
+}
+
+// after builds the condition selecting rows that come after the cursor position in this order
+func (s blogSort) after() string {
+	op := ">"
+	if s.desc {
+		op = "<"
+	}
+	return fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", s.key, op)
+}
+
+// findBlogSort looks up a sort by name, falling back to newest first
+func findBlogSort(name string) (blogSort, bool) {
+	for _, sort := range blogSorts {
+		if sort.Name == name {
+			return sort, true
+		}
+	}
+	return blogSorts[0], name == ""
+}
+
+// blogCursor marks the last post of a page. It is opaque to clients.
+type blogCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"i"`
+}
+
+func encodeCursor(cursor blogCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(encoded string, sort blogSort) (blogCursor, error) {
+	var cursor blogCursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, errInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sort.Name {
+		return cursor, errInvalidCursor
+	}
+	return cursor, nil
+}
+
+// countBlogs returns how many posts match a WHERE clause
+func countBlogs(where string, args ...any) (int, error) {
+	var total int
+	err := config.DB.QueryRow("SELECT COUNT(*) FROM blogs WHERE "+where, args...).Scan(&total)
+	return total, err
+}
+
+// queryBlogPage returns up to limit posts after the cursor, plus the cursor for the page after them if there is one
+func queryBlogPage(where string, args []any, sort blogSort, cursor *blogCursor, limit int) ([]models.Blog, *blogCursor, error) {
+	if cursor != nil {
+		where += " AND " + sort.after()
+		args = append(args, cursor.Value, cursor.Value, cursor.ID)
+	}
+
+	// Fetch one extra row to find out whether there is another page
+	rows, err := config.DB.Query(
+		"SELECT "+blogColumns+", CAST("+sort.key+" AS TEXT) FROM blogs WHERE "+where+" "+sort.orderBy()+" LIMIT ?",
+		append(args, limit+1)...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	blogs := []models.Blog{}
+	var sortValues []string
+	for rows.Next() {
+		var sortValue string
+		blog, err := scanBlog(rowScannerFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &sortValue)...)
+		}))
+		if err != nil {
+			return nil, nil, err
+		}
+		blogs = append(blogs, blog)
+		sortValues = append(sortValues, sortValue)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(blogs) <= limit {
+		return blogs, nil, nil
+	}
+
+	blogs = blogs[:limit]
+	last := blogs[limit-1]
+	return blogs, &blogCursor{Sort: sort.Name, Value: sortValues[limit-1], ID: last.ID}, nil
+}
+
+// pageURL is the current request's URL with some query parameters replaced or, when empty, removed
+func pageURL(c *fiber.Ctx, overrides map[string]string) string {
+	query := url.Values{}
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		query.Add(string(key), string(value))
+	})
+	for key, value := range overrides {
+		if value == "" {
+			query.Del(key)
+		} else {
+			query.Set(key, value)
+		}
+	}
+
+	if encoded := query.Encode(); encoded != "" {
+		return c.Path() + "?" + encoded
+	}
+	return c.Path()
+}
+
+// pageLink is one entry in a numbered page navigation
+type pageLink struct {
+	Number  int
+	URL     string
+	Current bool
+}
+
+// pageLinks builds the numbered navigation for a listing split into pages
+func pageLinks(c *fiber.Ctx, current int, totalPages int) []pageLink {
+	if totalPages <= 1 {
+		return nil
+	}
+
+	links := make([]pageLink, 0, totalPages)
+	for number := 1; number <= totalPages; number++ {
+		page := strconv.Itoa(number)
+		if number == 1 {
+			page = ""
+		}
+		links = append(links, pageLink{Number: number, URL: pageURL(c, map[string]string{"page": page}), Current: number == current})
+	}
+	return links
+}
+
+// sortLink is one option in the sort selector of a listing
+type sortLink struct {
+	Label   string
+	URL     string
+	Current bool
+}
+
+func sortLinks(c *fiber.Ctx, current blogSort) []sortLink {
+	links := make([]sortLink, 0, len(blogSorts))
+	for _, sort := range blogSorts {
+		name := sort.Name
+		if name == blogSorts[0].Name {
+			name = ""
+		}
+		links = append(links, sortLink{
+			Label:   sort.Label,
+			URL:     pageURL(c, map[string]string{"sort": name, "page": ""}),
+			Current: sort.Name == current.Name,
+		})
+	}
+	return links
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const mergePatchContentType = "application/merge-patch+json"
+
+var errBlogChanged = errors.New("blog has been changed since it was loaded; reload it and try again")
+
+// patchableBlogFields are the fields of a post a merge patch may change; the rest are worked out by the server
+var patchableBlogFields = []string{
+	"title", "slug", "content", "content_format", "author", "status", "publish_at",
+	"category", "tags", "series", "series_order", "meta_description", "canonical_url",
+}
+
+// blogVersion identifies a saved version of a post by the stored text of its updated_at column and its latest revision
+type blogVersion struct {
+	id        int
+	updatedAt string
+	revision  int
+}
+
+// getBlogVersion looks up the version a post is currently at
+func getBlogVersion(id int) (blogVersion, error) {
+	version := blogVersion{id: id}
+	err := config.DB.QueryRow(
+		"SELECT CAST(updated_at AS TEXT), (SELECT COALESCE(MAX(id), 0) FROM blog_revisions WHERE blog_id = blogs.id) FROM blogs WHERE id = ?", id,
+	).Scan(&version.updatedAt, &version.revision)
+	return version, err
+}
+
+// etag is the strong entity tag of the version
+func (v blogVersion) etag() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\x00%s\x00%d", v.id, v.updatedAt, v.revision)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ifMatch reports whether the version satisfies the request's If-Match header, if it sent one.
+// Changes need an exact match, so weak tags never match.
+func ifMatch(c *fiber.Ctx, version blogVersion) bool {
+	header := c.Get(fiber.HeaderIfMatch)
+	if header == "" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == version.etag() {
+			return true
+		}
+	}
+	return false
+}
+
+// setBlogETag sends the ETag of the version a post is currently at
+func setBlogETag(c *fiber.Ctx, id int) {
+	version, err := getBlogVersion(id)
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to look up version of blog %d: %v", id, err))
+		return
+	}
+	c.Set(fiber.HeaderETag, version.etag())
+}
+
+// mergePatch applies a JSON Merge Patch to a decoded JSON document as described in RFC 7396: objects are merged
+// key by key, null removes a key and anything else replaces the value outright
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+// blogDocument is the JSON a merge patch to a post is applied to, holding just the fields it may change
+func blogDocument(blog models.Blog) (map[string]any, error) {
+	data, err := json.Marshal(blog)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	for key := range document {
+		if !slices.Contains(patchableBlogFields, key) {
+			delete(document, key)
+		}
+	}
+	return document, nil
+}
+
+// PatchBlog changes the fields of a blog post given in a JSON Merge Patch, leaving the rest as they are.
+// A field set to null is cleared. Sending the post's ETag in If-Match makes the change fail with
+// 412 Precondition Failed if someone else has saved the post since it was loaded.
+func PatchBlog(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid blog ID"})
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	if mediaType != mergePatchContentType && mediaType != fiber.MIMEApplicationJSON {
+		return c.Status(415).JSON(fiber.Map{"error": "Content-Type must be " + mergePatchContentType})
+	}
+
+	current, err := scanBlog(config.DB.QueryRow("SELECT "+blogColumns+" FROM blogs WHERE id = ?", id))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Blog not found"})
+	}
+
+	version, err := getBlogVersion(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ifMatch(c, version) {
+		return sendBlogError(c, errBlogChanged)
+	}
+
+	var patch map[string]any
+	if err := json.Unmarshal(c.Body(), &patch); err != nil || patch == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Request body must be a JSON object"})
+	}
+
+	invalid := blogValidationError{}
+	for field := range patch {
+		if !slices.Contains(patchableBlogFields, field) {
+			invalid[field] = field + " cannot be changed"
+		}
+	}
+	if len(invalid) > 0 {
+		return sendBlogError(c, invalid)
+	}
+
+	document, err := blogDocument(current)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	merged, err := json.Marshal(mergePatch(document, patch))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var blog models.Blog
+	if err := json.Unmarshal(merged, &blog); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return sendBlogError(c, invalidField(typeErr.Field, "%s has the wrong type", typeErr.Field))
+		}
+		// The merged document is valid JSON, so anything else is a publish time that would not parse
+		return sendBlogError(c, invalidField("publish_at", "publish_at must be an RFC 3339 date and time"))
+	}
+	// Removing the tags clears them, where a PUT sending null for them leaves them alone
+	if blog.Tags == nil {
+		blog.Tags = []string{}
+	}
+
+	if err := saveBlogUpdate(c, id, current, version, &blog); err != nil {
+		return sendBlogError(c, err)
+	}
+
+	updated, err := scanBlog(config.DB.QueryRow("SELECT "+blogColumns+" FROM blogs WHERE id = ?", id))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	setBlogETag(c, id)
+	return c.JSON(updated)
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"database/sql"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxRelatedPosts = 3
+
+// tagSimilarityWeight is how much shared tags count towards two posts being related, against similar wording
+const tagSimilarityWeight = 0.5
+
+// relatedTermWeights caches the TF-IDF term weights of every post, drafts included, worked out from the search index.
+// It is cleared whenever a post is indexed or removed from the index.
+var relatedTermWeights = struct {
+	sync.Mutex
+	weights map[int]map[string]float64
+}{}
+
+// invalidateRelatedTerms drops the cached term weights so they are worked out again on next use
+func invalidateRelatedTerms() {
+	relatedTermWeights.Lock()
+	defer relatedTermWeights.Unlock()
+	relatedTermWeights.weights = nil
+}
+
+// getTermWeights returns the term weights of every post by ID, working them out if a post has changed since
+// they were last needed. The maps returned are shared and must not be changed.
+func getTermWeights() (map[int]map[string]float64, error) {
+	relatedTermWeights.Lock()
+	defer relatedTermWeights.Unlock()
+	if relatedTermWeights.weights != nil {
+		return relatedTermWeights.weights, nil
+	}
+
+	rows, err := config.DB.Query("SELECT doc, term, COUNT(*) FROM blogs_fts_terms GROUP BY doc, term")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := map[int]map[string]float64{}
+	for rows.Next() {
+		var id, count int
+		var term string
+		if err := rows.Scan(&id, &term, &count); err != nil {
+			return nil, err
+		}
+		if terms[id] == nil {
+			terms[id] = map[string]float64{}
+		}
+		terms[id][term] = float64(count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	weighTerms(terms)
+	relatedTermWeights.weights = terms
+	return terms, nil
+}
+
+// relatedCandidate is a post that might be suggested as related, with the terms it uses
+type relatedCandidate struct {
+	link   models.BlogLink
+	series string
+	tags   map[string]bool
+	terms  map[string]float64
+	score  float64
+}
+
+// getRelatedCandidates loads every post visible to the caller with its tags and the weights of the terms it uses.
+// Terms come from the search index, so they are stemmed the same way searches are.
+func getRelatedCandidates(c *fiber.Ctx) (map[int]*relatedCandidate, error) {
+	weights, err := getTermWeights()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := config.DB.Query("SELECT " + blogLinkColumns + ", series, " + blogTagsColumn + " FROM blogs WHERE " + blogVisibilityFilter(c))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := map[int]*relatedCandidate{}
+	for rows.Next() {
+		candidate := &relatedCandidate{tags: map[string]bool{}}
+		var tags sql.NullString
+		link, err := scanBlogLink(rowScannerFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &candidate.series, &tags)...)
+		}))
+		if err != nil {
+			return nil, err
+		}
+		candidate.link = link
+		candidate.terms = weights[link.ID]
+		for _, tag := range splitTags(tags.String) {
+			candidate.tags[tag] = true
+		}
+		candidates[link.ID] = candidate
+	}
+	return candidates, rows.Err()
+}
+
+// weighTerms turns each post's term counts into TF-IDF weights normalized to unit length, so terms
+// that appear in every post count for nothing and long posts do not outweigh short ones
+func weighTerms(terms map[int]map[string]float64) {
+	documentFrequency := map[string]int{}
+	for _, counts := range terms {
+		for term := range counts {
+			documentFrequency[term]++
+		}
+	}
+
+	total := float64(len(terms))
+	for _, counts := range terms {
+		var length float64
+		for term, count := range counts {
+			weight := (1 + math.Log(count)) * math.Log(total/float64(documentFrequency[term]))
+			counts[term] = weight
+			length += weight * weight
+		}
+		if length == 0 {
+			continue
+		}
+		length = math.Sqrt(length)
+		for term := range counts {
+			counts[term] /= length
+		}
+	}
+}
+
+// contentSimilarity is the cosine similarity of two posts' normalized term weights
+func contentSimilarity(a *relatedCandidate, b *relatedCandidate) float64 {
+	if len(b.terms) < len(a.terms) {
+		a, b = b, a
+	}
+	var similarity float64
+	for term, weight := range a.terms {
+		similarity += weight * b.terms[term]
+	}
+	return similarity
+}
+
+// tagSimilarity is the share of two posts' combined tags that both have
+func tagSimilarity(a *relatedCandidate, b *relatedCandidate) float64 {
+	shared := 0
+	for tag := range a.tags {
+		if b.tags[tag] {
+			shared++
+		}
+	}
+	combined := len(a.tags) + len(b.tags) - shared
+	if combined == 0 {
+		return 0
+	}
+	return float64(shared) / float64(combined)
+}
+
+// getRelatedPosts suggests the posts visible to the caller most like the given one, scored on shared tags and
+// the TF-IDF similarity of their content. Other parts of the post's series are left out as the series
+// navigation already links them.
+func getRelatedPosts(c *fiber.Ctx, blog models.Blog) ([]models.BlogLink, error) {
+	candidates, err := getRelatedCandidates(c)
+	if err != nil {
+		return nil, err
+	}
+
+	post, ok := candidates[blog.ID]
+	if !ok {
+		return []models.BlogLink{}, nil
+	}
+
+	var scored []*relatedCandidate
+	for id, candidate := range candidates {
+		if id == blog.ID || (blog.Series != "" && candidate.series == blog.Series) {
+			continue
+		}
+		candidate.score = tagSimilarityWeight*tagSimilarity(post, candidate) + (1-tagSimilarityWeight)*contentSimilarity(post, candidate)
+		if candidate.score > 0 {
+			scored = append(scored, candidate)
+		}
+	}
+
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].score != scored[j].score {
+			return scored[i].score > scored[j].score
+		}
+		return scored[i].link.PostedAt.After(scored[j].link.PostedAt)
+	})
+
+	related := []models.BlogLink{}
+	for _, candidate := range scored[:min(len(scored), maxRelatedPosts)] {
+		related = append(related, candidate.link)
+	}
+	return related, nil
+}
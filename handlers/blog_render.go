@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.GFM,
+		highlighting.NewHighlighting(
+			highlighting.WithStyle("github"),
+			highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+		),
+	),
+	goldmark.WithRendererOptions(html.WithUnsafe()),
+)
+
+// blogPolicy is the allowlist every rendered post goes through before it is stored
+var blogPolicy = newBlogPolicy()
+
+func newBlogPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// Syntax highlighting emits class names such as "chroma", "k" or "nf"
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-zA-Z0-9\s_-]+$`)).OnElements("pre", "code", "span", "div")
+	p.AllowAttrs("target").Matching(regexp.MustCompile(`^_blank$`)).OnElements("a")
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// IsValidContentFormat reports whether format is one posts can be authored in
+func IsValidContentFormat(format string) bool {
+	return format == models.ContentFormatMarkdown || format == models.ContentFormatHTML
+}
+
+// RenderBlogContent converts post source into sanitized HTML ready to be served
+func RenderBlogContent(content string, format string) (template.HTML, error) {
+	var unsafe []byte
+
+	switch format {
+	case models.ContentFormatMarkdown:
+		var buf bytes.Buffer
+		if err := markdown.Convert([]byte(content), &buf); err != nil {
+			return "", fmt.Errorf("failed to render markdown: %w", err)
+		}
+		unsafe = buf.Bytes()
+	case models.ContentFormatHTML:
+		unsafe = []byte(content)
+	default:
+		return "", fmt.Errorf("unknown content format %q", format)
+	}
+
+	// Anchors go in after sanitizing, which strips id attributes
+	anchored, _ := addHeadingAnchors(blogPolicy.Sanitize(string(unsafe)))
+	return template.HTML(anchored), nil
+}
+
+// RenderPendingBlogs fills in content_html and the outline for posts that have not been rendered yet,
+// such as legacy HTML posts migrated from before Markdown support
+func RenderPendingBlogs() error {
+	rows, err := config.DB.Query("SELECT id, title, content, content_format FROM blogs WHERE content_html = ''")
+	if err != nil {
+		return err
+	}
+
+	var pending []models.Blog
+	for rows.Next() {
+		var blog models.Blog
+		if err := rows.Scan(&blog.ID, &blog.Title, &blog.Content, &blog.ContentFormat); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, blog)
+	}
+	rows.Close()
+
+	for _, blog := range pending {
+		rendered, err := RenderBlogContent(blog.Content, blog.ContentFormat)
+		if err != nil {
+			config.LogMessage("ERROR", fmt.Sprintf("Failed to render blog %d: %v", blog.ID, err))
+			continue
+		}
+
+		words, readingTime, toc := outlineBlog(rendered)
+		_, err = config.DB.Exec(
+			"UPDATE blogs SET content_html = ?, word_count = ?, reading_time = ?, toc = ? WHERE id = ?",
+			string(rendered), words, readingTime, marshalTOC(toc), blog.ID,
+		)
+		if err != nil {
+			return err
+		}
+		if err := indexBlog(config.DB, blog.ID, blog.Title, rendered); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/middleware"
+	"PersonalWebsiteGO/models"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const revisionColumns = "id, blog_id, title, content, content_format, author, saved_by, restored_from, created_at"
+
+func scanRevision(row rowScanner) (models.BlogRevision, error) {
+	var revision models.BlogRevision
+	var restoredFrom sql.NullInt64
+	err := row.Scan(&revision.ID, &revision.BlogID, &revision.Title, &revision.Content, &revision.ContentFormat,
+		&revision.Author, &revision.SavedBy, &restoredFrom, &revision.CreatedAt)
+	if restoredFrom.Valid {
+		id := int(restoredFrom.Int64)
+		revision.RestoredFrom = &id
+	}
+	return revision, err
+}
+
+// saveBlogRevision records the post's current title, content and author as a new revision
+func saveBlogRevision(db querier, blogID int, savedBy string, restoredFrom *int) error {
+	_, err := db.Exec(
+		"INSERT INTO blog_revisions (blog_id, title, content, content_format, author, saved_by, restored_from, created_at) "+
+			"SELECT id, title, content, content_format, author, ?, ?, ? FROM blogs WHERE id = ?",
+		savedBy, restoredFrom, time.Now(), blogID,
+	)
+	return err
+}
+
+// getRevision loads one revision of a post
+func getRevision(blogID int, revisionID int) (models.BlogRevision, error) {
+	return scanRevision(config.DB.QueryRow(
+		"SELECT "+revisionColumns+" FROM blog_revisions WHERE id = ? AND blog_id = ?", revisionID, blogID,
+	))
+}
+
+// GetBlogRevisions lists every saved version of a post, newest first
+func GetBlogRevisions(c *fiber.Ctx) error {
+	blogID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid blog ID"})
+	}
+
+	rows, err := config.DB.Query("SELECT "+revisionColumns+" FROM blog_revisions WHERE blog_id = ? ORDER BY id DESC", blogID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+
+	revisions := []models.BlogRevision{}
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if len(revisions) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Blog not found"})
+	}
+
+	return c.JSON(revisions)
+}
+
+// revisionDocument lays out a revision as text so changes to any field show up in a diff
+func revisionDocument(revision models.BlogRevision) string {
+	return fmt.Sprintf("Title: %s\nAuthor: %s\nFormat: %s\n\n%s\n", revision.Title, revision.Author, revision.ContentFormat, revision.Content)
+}
+
+// DiffBlogRevisions returns a unified diff between two revisions of a post, given as ?from= and ?to= revision IDs
+func DiffBlogRevisions(c *fiber.Ctx) error {
+	blogID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid blog ID"})
+	}
+
+	fromID, toID := c.QueryInt("from"), c.QueryInt("to")
+	if fromID == 0 || toID == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Query parameters from and to are required"})
+	}
+
+	from, err := getRevision(blogID, fromID)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": fmt.Sprintf("Revision %d not found", fromID)})
+	}
+	to, err := getRevision(blogID, toID)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": fmt.Sprintf("Revision %d not found", toID)})
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(revisionDocument(from)),
+		B:        difflib.SplitLines(revisionDocument(to)),
+		FromFile: fmt.Sprintf("revision %d", from.ID),
+		FromDate: from.CreatedAt.Format(time.RFC3339),
+		ToFile:   fmt.Sprintf("revision %d", to.ID),
+		ToDate:   to.CreatedAt.Format(time.RFC3339),
+		Context:  3,
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if strings.Contains(c.Get(fiber.HeaderAccept), fiber.MIMEApplicationJSON) {
+		return c.JSON(fiber.Map{"from": from.ID, "to": to.ID, "diff": diff})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/x-diff; charset=utf-8")
+	return c.SendString(diff)
+}
+
+// RestoreBlogRevision puts a post back to an earlier revision. The restore is saved as a new revision
+// so the history leading up to it is kept. Like any other change it fails with 412 Precondition Failed if
+// the ETag sent in If-Match is not the post's current one.
+func RestoreBlogRevision(c *fiber.Ctx) error {
+	blogID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid blog ID"})
+	}
+	revisionID, err := c.ParamsInt("revisionId")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid revision ID"})
+	}
+
+	version, err := getBlogVersion(blogID)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Blog not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ifMatch(c, version) {
+		return sendBlogError(c, errBlogChanged)
+	}
+
+	revision, err := getRevision(blogID, revisionID)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Revision not found"})
+	}
+
+	rendered, err := RenderBlogContent(revision.Content, revision.ContentFormat)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	words, readingTime, toc := outlineBlog(rendered)
+	result, err := config.DB.Exec(
+		"UPDATE blogs SET title = ?, content = ?, content_format = ?, content_html = ?, author = ?, word_count = ?, reading_time = ?, toc = ?, updated_at = ? WHERE id = ? AND updated_at = ?",
+		revision.Title, revision.Content, revision.ContentFormat, string(rendered), revision.Author, words, readingTime, marshalTOC(toc), time.Now(), blogID, version.updatedAt,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return sendBlogError(c, errBlogChanged)
+	}
+
+	if err := indexBlog(config.DB, blogID, revision.Title, rendered); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to index blog %d for search: %v", blogID, err))
+	}
+
+	if err := saveBlogRevision(config.DB, blogID, middleware.CurrentUsername(c), &revision.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	setBlogETag(c, blogID)
+	return c.JSON(fiber.Map{"message": fmt.Sprintf("Blog restored to revision %d", revision.ID)})
+}
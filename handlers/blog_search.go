@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"errors"
+	"html"
+	"html/template"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	maxSearchLength    = 200
+)
+
+// Markers FTS5 wraps matches in; they are swapped for <mark> tags once the rest of the text is escaped
+const (
+	matchStart = "\x01"
+	matchEnd   = "\x02"
+)
+
+var errEmptySearch = errors.New("search query must contain at least one word")
+
+var textOnlyPolicy = bluemonday.StrictPolicy()
+
+// plainText strips the tags from rendered post HTML, leaving the words to index
+func plainText(contentHTML string) string {
+	return html.UnescapeString(textOnlyPolicy.Sanitize(contentHTML))
+}
+
+// indexBlog adds or replaces a post in the full-text search index
+func indexBlog(db querier, id int, title string, contentHTML template.HTML) error {
+	if err := unindexBlog(db, id); err != nil {
+		return err
+	}
+	_, err := db.Exec("INSERT INTO blogs_fts (rowid, title, body) VALUES (?, ?, ?)", id, title, plainText(string(contentHTML)))
+	invalidateRelatedTerms()
+	return err
+}
+
+// unindexBlog removes a post from the full-text search index
+func unindexBlog(db querier, id int) error {
+	_, err := db.Exec("DELETE FROM blogs_fts WHERE rowid = ?", id)
+	invalidateRelatedTerms()
+	return err
+}
+
+// RebuildSearchIndex re-indexes every post when the index is out of step with the blogs table,
+// such as the first start after search was added
+func RebuildSearchIndex() error {
+	var blogCount, indexedCount int
+	if err := config.DB.QueryRow("SELECT COUNT(*) FROM blogs").Scan(&blogCount); err != nil {
+		return err
+	}
+	if err := config.DB.QueryRow("SELECT COUNT(*) FROM blogs_fts").Scan(&indexedCount); err != nil {
+		return err
+	}
+	if blogCount == indexedCount {
+		return nil
+	}
+
+	if _, err := config.DB.Exec("DELETE FROM blogs_fts"); err != nil {
+		return err
+	}
+
+	blogs, err := queryBlogs("")
+	if err != nil {
+		return err
+	}
+	for _, blog := range blogs {
+		if err := indexBlog(config.DB, blog.ID, blog.Title, blog.ContentHTML); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// buildSearchQuery turns what a visitor typed into a safe FTS5 query. Every word must match,
+// "quoted text" matches as a phrase and a trailing * matches any word starting with the prefix.
+func buildSearchQuery(input string) (string, error) {
+	var terms []string
+
+	addTerm := func(term string, phrase bool) {
+		prefix := !phrase && strings.HasSuffix(term, "*")
+		words := strings.FieldsFunc(term, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		if len(words) == 0 {
+			return
+		}
+		if !phrase {
+			// Punctuation inside a word splits it, e.g. "go-lang" matches go followed by lang
+			phrase = len(words) > 1
+		}
+
+		quoted := `"` + strings.Join(words, " ") + `"`
+		if prefix {
+			quoted += "*"
+		}
+		terms = append(terms, quoted)
+	}
+
+	for i, part := range strings.Split(input, `"`) {
+		// Odd numbered parts sit between a pair of quotes
+		if i%2 == 1 {
+			addTerm(part, true)
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			addTerm(word, false)
+		}
+	}
+
+	if len(terms) == 0 {
+		return "", errEmptySearch
+	}
+	return strings.Join(terms, " "), nil
+}
+
+// highlightMatches escapes FTS5 snippet output and turns its match markers into <mark> tags
+func highlightMatches(text string) template.HTML {
+	escaped := html.EscapeString(text)
+	escaped = strings.ReplaceAll(escaped, matchStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, matchEnd, "</mark>")
+	return template.HTML(escaped)
+}
+
+// searchBlogs returns the posts visible to the caller that match the query, best match first
+func searchBlogs(c *fiber.Ctx, input string, limit int) ([]models.BlogSearchResult, error) {
+	query, err := buildSearchQuery(input)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := config.DB.Query(`
+		WITH matches AS (
+			SELECT rowid AS blog_id,
+				bm25(blogs_fts, 10.0, 1.0) AS rank,
+				highlight(blogs_fts, 0, ?, ?) AS title_highlight,
+				snippet(blogs_fts, 1, ?, ?, '…', 24) AS snippet
+			FROM blogs_fts
+			WHERE blogs_fts MATCH ?
+		)
+		SELECT `+blogColumns+`, matches.title_highlight, matches.snippet
+		FROM blogs JOIN matches ON matches.blog_id = blogs.id
+		WHERE `+blogVisibilityFilter(c)+`
+		ORDER BY matches.rank
+		LIMIT ?`,
+		matchStart, matchEnd, matchStart, matchEnd, query, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.BlogSearchResult{}
+	for rows.Next() {
+		var result models.BlogSearchResult
+		var titleHighlight, snippet string
+		result.Blog, err = scanBlog(rowScannerFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &titleHighlight, &snippet)...)
+		}))
+		if err != nil {
+			return nil, err
+		}
+		result.TitleHighlight = highlightMatches(titleHighlight)
+		result.Snippet = highlightMatches(snippet)
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// SearchBlogs handles /api/blogs/search?q= with optional &limit=
+func SearchBlogs(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Query parameter q is required"})
+	}
+	if len(q) > maxSearchLength {
+		return c.Status(400).JSON(fiber.Map{"error": "Search query is too long"})
+	}
+
+	limit := c.QueryInt("limit", defaultSearchLimit)
+	if limit < 1 || limit > maxSearchLimit {
+		limit = defaultSearchLimit
+	}
+
+	results, err := searchBlogs(c, q, limit)
+	if err == errEmptySearch {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"query":   q,
+		"results": results,
+	})
+}
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	maxMetaDescriptionChars = 300
+	// Descriptions generated from a post's content are cut to what search results typically show
+	autoDescriptionChars = 160
+	maxCanonicalURLLen   = 2048
+)
+
+// Size of the social preview images, the 1.91:1 ratio Open Graph and Twitter cards expect
+const (
+	previewImageWidth  = 1200
+	previewImageHeight = 630
+)
+
+// previewImageVersion is part of every cached preview's name, so changing the design regenerates them all
+const previewImageVersion = "1"
+
+var (
+	previewBackground = color.RGBA{0x21, 0x25, 0x29, 0xff}
+	previewAccent     = color.RGBA{0x0d, 0x6e, 0xfd, 0xff}
+	previewTitle      = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	previewMuted      = color.RGBA{0xad, 0xb5, 0xbd, 0xff}
+)
+
+var (
+	previewBoldFont    = mustParseFont(gobold.TTF)
+	previewRegularFont = mustParseFont(goregular.TTF)
+)
+
+// pageMeta describes a page to search engines and link previews. layout/base.html turns it into
+// description, canonical, Open Graph and Twitter Card tags.
+type pageMeta struct {
+	Title         string
+	Description   string
+	URL           string
+	CanonicalURL  string
+	Image         string
+	ImageWidth    int
+	ImageHeight   int
+	ImageAlt      string
+	Type          string
+	SiteName      string
+	Author        string
+	PublishedTime string
+	ModifiedTime  string
+	Tags          []string
+}
+
+func mustParseFont(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// prepareBlogSEO tidies up the post's meta description and checks its canonical URL is absolute
+func prepareBlogSEO(blog *models.Blog) error {
+	blog.MetaDescription = strings.Join(strings.Fields(blog.MetaDescription), " ")
+	if utf8.RuneCountInString(blog.MetaDescription) > maxMetaDescriptionChars {
+		return invalidField("meta_description", "meta_description must be at most %d characters", maxMetaDescriptionChars)
+	}
+
+	blog.CanonicalURL = strings.TrimSpace(blog.CanonicalURL)
+	if blog.CanonicalURL == "" {
+		return nil
+	}
+	if len(blog.CanonicalURL) > maxCanonicalURLLen {
+		return invalidField("canonical_url", "canonical_url must be at most %d characters", maxCanonicalURLLen)
+	}
+	parsed, err := url.Parse(blog.CanonicalURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return invalidField("canonical_url", "canonical_url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// truncateWords shortens text to at most limit characters, cutting at a word boundary and adding an ellipsis
+func truncateWords(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+
+	runes := []rune(text)[:limit-1]
+	cut := string(runes)
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:-") + "…"
+}
+
+// blogDescription is the post's meta description, or the start of its text when it has none
+func blogDescription(blog models.Blog) string {
+	if blog.MetaDescription != "" {
+		return blog.MetaDescription
+	}
+	text := strings.Join(strings.Fields(plainText(string(blog.ContentHTML))), " ")
+	return truncateWords(text, autoDescriptionChars)
+}
+
+// blogCanonicalURL is where search engines should treat the post as living
+func blogCanonicalURL(blog models.Blog) string {
+	if blog.CanonicalURL != "" {
+		return blog.CanonicalURL
+	}
+	return blogURL(blog)
+}
+
+// previewImageDir is where generated social preview images are cached
+func previewImageDir() string {
+	if dir := os.Getenv("PREVIEW_IMAGE_DIR"); dir != "" {
+		return dir
+	}
+	return "./cache/previews"
+}
+
+// previewImageKey fingerprints everything drawn on a post's preview image, so it is regenerated when any of it changes
+func previewImageKey(blog models.Blog) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		previewImageVersion, siteURL(), blog.Title, blog.Author, blog.PostedAt().Format("2006-01-02"),
+	}, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// blogPreviewImageURL is the absolute address of a post's social preview image. The key in the query string
+// makes sites that cache previews fetch the new image after the post changes.
+func blogPreviewImageURL(blog models.Blog) string {
+	return blogURL(blog) + "/preview.png?v=" + previewImageKey(blog)
+}
+
+// blogPageMeta describes a post for search engines and link previews
+func blogPageMeta(blog models.Blog) pageMeta {
+	meta := pageMeta{
+		Title:        blog.Title,
+		Description:  blogDescription(blog),
+		URL:          blogURL(blog),
+		CanonicalURL: blogCanonicalURL(blog),
+		Image:        blogPreviewImageURL(blog),
+		ImageWidth:   previewImageWidth,
+		ImageHeight:  previewImageHeight,
+		ImageAlt:     blog.Title,
+		Type:         "article",
+		SiteName:     siteAuthor(),
+		Author:       blog.Author,
+		ModifiedTime: blog.UpdatedAt.UTC().Format(time.RFC3339),
+		Tags:         blog.Tags,
+	}
+	if blog.Status == models.BlogStatusPublished {
+		meta.PublishedTime = blog.PostedAt().UTC().Format(time.RFC3339)
+	}
+	return meta
+}
+
+// wrapText breaks text into lines no wider than maxWidth, ending with an ellipsis if it needs more than maxLines
+func wrapText(face font.Face, text string, maxWidth int, maxLines int) []string {
+	limit := fixed.I(maxWidth)
+	fits := func(s string) bool { return font.MeasureString(face, s) <= limit }
+
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := strings.TrimSpace(line + " " + word)
+		if fits(candidate) {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		// Words too long for a line on their own are split wherever they overflow
+		line = ""
+		for _, r := range word {
+			if line != "" && !fits(line+string(r)) {
+				lines = append(lines, line)
+				line = ""
+			}
+			line += string(r)
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		last := lines[maxLines-1]
+		for last != "" && !fits(last+"…") {
+			_, size := utf8.DecodeLastRuneInString(last)
+			last = last[:len(last)-size]
+		}
+		lines[maxLines-1] = strings.TrimRight(last, " ") + "…"
+	}
+	return lines
+}
+
+// drawText writes one line of text with its baseline at (x, y)
+func drawText(dst draw.Image, face font.Face, c color.Color, x int, y int, text string) {
+	drawer := font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y)}
+	drawer.DrawString(text)
+}
+
+// loadPreviewLogo reads the site logo used on preview images, returning nil if it is unavailable
+func loadPreviewLogo() image.Image {
+	file, err := os.Open("./static/images/SmallLogo.png")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	logo, _, err := image.Decode(file)
+	if err != nil {
+		return nil
+	}
+	return logo
+}
+
+// renderPreviewImage draws a post's social preview card: site branding, the title, and when and by whom it was posted
+func renderPreviewImage(blog models.Blog) ([]byte, error) {
+	titleFace, err := opentype.NewFace(previewBoldFont, &opentype.FaceOptions{Size: 68, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer titleFace.Close()
+
+	textFace, err := opentype.NewFace(previewRegularFont, &opentype.FaceOptions{Size: 34, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer textFace.Close()
+
+	img := image.NewRGBA(image.Rect(0, 0, previewImageWidth, previewImageHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(previewBackground), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 24, previewImageHeight), image.NewUniform(previewAccent), image.Point{}, draw.Src)
+
+	const margin = 96
+	brandX := margin
+	if logo := loadPreviewLogo(); logo != nil {
+		// The logo is dark, so it sits on a light tile to stand out from the background
+		tile := image.Rect(margin, 56, margin+96, 152)
+		draw.Draw(img, tile, image.NewUniform(previewTitle), image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(img, tile.Inset(10), logo, logo.Bounds(), draw.Over, nil)
+		brandX = tile.Max.X + 24
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(siteURL(), "https://"), "http://")
+	drawText(img, textFace, previewMuted, brandX, 116, host)
+
+	lines := wrapText(titleFace, blog.Title, previewImageWidth-2*margin, 3)
+	lineHeight := 84
+	top := 230 + (3-len(lines))*lineHeight/2
+	for i, line := range lines {
+		drawText(img, titleFace, previewTitle, margin, top+i*lineHeight, line)
+	}
+
+	byline := blog.PostedAt().Format("January 2, 2006")
+	if blog.Author != "" {
+		byline += "  ·  " + blog.Author
+	}
+	drawText(img, textFace, previewMuted, margin, previewImageHeight-72, byline)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// removeBlogPreviewImages deletes a post's cached preview images, keeping the one named keep if given
+func removeBlogPreviewImages(blogID int, keep string) {
+	matches, err := filepath.Glob(filepath.Join(previewImageDir(), strconv.Itoa(blogID)+"-*.png"))
+	if err != nil {
+		return
+	}
+	for _, path := range matches {
+		if filepath.Base(path) == keep {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			config.LogMessage("ERROR", fmt.Sprintf("Failed to remove preview image %s: %v", path, err))
+		}
+	}
+}
+
+// getBlogPreviewImage returns a post's preview image from the cache, drawing it first if the post has changed since
+func getBlogPreviewImage(blog models.Blog) ([]byte, error) {
+	name := fmt.Sprintf("%d-%s.png", blog.ID, previewImageKey(blog))
+	path := filepath.Join(previewImageDir(), name)
+
+	if data, err := os.ReadFile(path); err == nil {
+		return data, nil
+	}
+
+	data, err := renderPreviewImage(blog)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(previewImageDir(), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+	removeBlogPreviewImages(blog.ID, name)
+
+	return data, nil
+}
+
+// BlogPreviewImage serves the PNG shown when a post is shared, at /blog/:slug/preview.png
+func BlogPreviewImage(c *fiber.Ctx) error {
+	blog, err := scanBlog(config.DB.QueryRow("SELECT "+blogColumns+" FROM blogs WHERE slug = ? AND "+blogVisibilityFilter(c), c.Params("slug")))
+	if err == sql.ErrNoRows {
+		return fiber.ErrNotFound
+	}
+	if err != nil {
+		fmt.Println("Error fetching blog:", err)
+		return fiber.ErrInternalServerError
+	}
+
+	data, err := getBlogPreviewImage(blog)
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to generate preview image for blog %d: %v", blog.ID, err))
+		return fiber.ErrInternalServerError
+	}
+
+	return sendConditional(c, data, "image/png", blogLastModified(blog))
+}
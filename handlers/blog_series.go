@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"database/sql"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxSeriesNameLength = 100
+
+// seriesOrder lists a series' parts by their position, falling back to when they were posted
+const seriesOrder = "ORDER BY series_order, COALESCE(publish_at, created_at), id"
+
+// blogLinkColumns selects what scanBlogLink needs to link to a post
+const blogLinkColumns = "id, title, slug, publish_at, created_at"
+
+// prepareBlogSeries tidies the name of the series the post belongs to and checks its position in it
+func prepareBlogSeries(blog *models.Blog) error {
+	blog.Series = strings.Join(strings.Fields(blog.Series), " ")
+	if blog.Series == "" {
+		blog.SeriesOrder = 0
+		return nil
+	}
+
+	if utf8.RuneCountInString(blog.Series) > maxSeriesNameLength {
+		return invalidField("series", "series must be at most %d characters", maxSeriesNameLength)
+	}
+	if blog.SeriesOrder < 0 {
+		return invalidField("series_order", "series_order must not be negative")
+	}
+
+	return nil
+}
+
+// assignSeriesOrder places a post in its series when no position was given: it keeps the place it already
+// had there, or becomes the series' last part
+func assignSeriesOrder(db querier, blog *models.Blog, id int) error {
+	if blog.Series == "" || blog.SeriesOrder > 0 {
+		return nil
+	}
+
+	err := db.QueryRow("SELECT series_order FROM blogs WHERE id = ? AND series = ?", id, blog.Series).Scan(&blog.SeriesOrder)
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	return db.QueryRow(
+		"SELECT COALESCE(MAX(series_order), 0) + 1 FROM blogs WHERE series = ? AND id != ?", blog.Series, id,
+	).Scan(&blog.SeriesOrder)
+}
+
+// blogLink makes a link to a post
+func blogLink(blog models.Blog) models.BlogLink {
+	return models.BlogLink{ID: blog.ID, Title: blog.Title, Slug: blog.Slug, PostedAt: blog.PostedAt()}
+}
+
+// scanBlogLink reads the columns selected by blogLinkColumns
+func scanBlogLink(row rowScanner) (models.BlogLink, error) {
+	var blog models.Blog
+	var publishAt sql.NullTime
+	err := row.Scan(&blog.ID, &blog.Title, &blog.Slug, &publishAt, &blog.CreatedAt)
+	if publishAt.Valid {
+		blog.PublishAt = &publishAt.Time
+	}
+	return blogLink(blog), err
+}
+
+// getSeriesNavigation lists the parts of the post's series visible to the caller and where the post sits among them
+func getSeriesNavigation(c *fiber.Ctx, blog models.Blog) (*models.SeriesNavigation, error) {
+	if blog.Series == "" {
+		return nil, nil
+	}
+
+	rows, err := config.DB.Query(
+		"SELECT "+blogLinkColumns+" FROM blogs WHERE series = ? AND "+blogVisibilityFilter(c)+" "+seriesOrder, blog.Series,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	navigation := &models.SeriesNavigation{Name: blog.Series, Posts: []models.BlogLink{}}
+	for rows.Next() {
+		link, err := scanBlogLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		navigation.Posts = append(navigation.Posts, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, part := range navigation.Posts {
+		if part.ID != blog.ID {
+			continue
+		}
+		navigation.Position = i + 1
+		if i > 0 {
+			navigation.Previous = &navigation.Posts[i-1]
+		}
+		if i < len(navigation.Posts)-1 {
+			navigation.Next = &navigation.Posts[i+1]
+		}
+	}
+
+	return navigation, nil
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const maxSlugLength = 80
+
+var slugInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a post title into a lowercase, hyphen separated URL segment
+func Slugify(title string) string {
+	slug := slugInvalidChars.ReplaceAllString(strings.ToLower(title), "-")
+	slug = strings.Trim(slug, "-")
+
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	if slug == "" {
+		slug = "post"
+	}
+
+	return slug
+}
+
+// IsValidSlug reports whether slug is already in the form Slugify would produce
+func IsValidSlug(slug string) bool {
+	return slug != "" && Slugify(slug) == slug
+}
+
+// uniqueSlug returns base, or base with a numeric suffix, such that no other post uses it.
+// excludeID is the post being saved so it does not collide with itself.
+func uniqueSlug(base string, excludeID int) (string, error) {
+	slug := base
+	for i := 2; ; i++ {
+		var id int
+		err := config.DB.QueryRow("SELECT id FROM blogs WHERE slug = ? AND id != ?", slug, excludeID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return slug, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// recordSlugChange stores a permanent redirect from a post's previous slug to the post
+func recordSlugChange(blogID int, oldSlug string, newSlug string) error {
+	if oldSlug == "" || oldSlug == newSlug {
+		return nil
+	}
+
+	// The new slug may have been an old one; it now belongs to a live post so it must not redirect
+	if _, err := config.DB.Exec("DELETE FROM blog_slug_redirects WHERE old_slug = ?", newSlug); err != nil {
+		return err
+	}
+
+	_, err := config.DB.Exec(
+		"INSERT INTO blog_slug_redirects (old_slug, blog_id) VALUES (?, ?) ON CONFLICT(old_slug) DO UPDATE SET blog_id = excluded.blog_id",
+		oldSlug, blogID,
+	)
+	return err
+}
+
+// BackfillBlogSlugs generates slugs for posts created before slugs existed
+func BackfillBlogSlugs() error {
+	rows, err := config.DB.Query("SELECT id, title FROM blogs WHERE slug IS NULL OR slug = '' ORDER BY id")
+	if err != nil {
+		return err
+	}
+
+	type pendingSlug struct {
+		id    int
+		title string
+	}
+
+	var pending []pendingSlug
+	for rows.Next() {
+		var p pendingSlug
+		if err := rows.Scan(&p.id, &p.title); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+
+	for _, p := range pending {
+		slug, err := uniqueSlug(Slugify(p.title), p.id)
+		if err != nil {
+			return err
+		}
+		if _, err := config.DB.Exec("UPDATE blogs SET slug = ? WHERE id = ?", slug, p.id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var (
+	errSlugTaken   = errors.New("slug is already used by another post")
+	errInvalidSlug = errors.New("slug may only contain lowercase letters, numbers and single hyphens")
+)
+
+// assignBlogSlug validates a requested slug, or generates a unique one from the title when none was given.
+// excludeID is the ID of the post being saved, or 0 for a new post.
+func assignBlogSlug(blog *models.Blog, excludeID int) error {
+	if blog.Slug == "" {
+		slug, err := uniqueSlug(Slugify(blog.Title), excludeID)
+		if err != nil {
+			return err
+		}
+		blog.Slug = slug
+		return nil
+	}
+
+	if !IsValidSlug(blog.Slug) {
+		return fmt.Errorf("%w, e.g. %q", errInvalidSlug, Slugify(blog.Slug))
+	}
+
+	slug, err := uniqueSlug(blog.Slug, excludeID)
+	if err != nil {
+		return err
+	}
+	if slug != blog.Slug {
+		return errSlugTaken
+	}
+
+	return nil
+}
+
+// slugErrorStatus maps an assignBlogSlug error onto the HTTP status to respond with
+func slugErrorStatus(err error) int {
+	if errors.Is(err, errSlugTaken) {
+		return 409
+	}
+	if errors.Is(err, errInvalidSlug) {
+		return 400
+	}
+	return 500
+}
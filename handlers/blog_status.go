@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"fmt"
+	"time"
+)
+
+// IsValidBlogStatus reports whether status is a known publication state
+func IsValidBlogStatus(status string) bool {
+	switch status {
+	case models.BlogStatusDraft, models.BlogStatusPublished, models.BlogStatusScheduled, models.BlogStatusArchived:
+		return true
+	}
+	return false
+}
+
+// normalizePublishTime strips the monotonic clock and zone so stored times compare correctly as text
+func normalizePublishTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+// prepareBlogStatus validates the post's status and works out when it goes live
+func prepareBlogStatus(blog *models.Blog, now time.Time) error {
+	if blog.Status == "" {
+		blog.Status = models.BlogStatusPublished
+	}
+	if !IsValidBlogStatus(blog.Status) {
+		return invalidField("status", "status must be one of %q, %q, %q or %q",
+			models.BlogStatusDraft, models.BlogStatusPublished, models.BlogStatusScheduled, models.BlogStatusArchived)
+	}
+
+	if blog.PublishAt != nil {
+		publishAt := normalizePublishTime(*blog.PublishAt)
+		blog.PublishAt = &publishAt
+	}
+
+	switch blog.Status {
+	case models.BlogStatusScheduled:
+		if blog.PublishAt == nil {
+			return invalidField("publish_at", "publish_at is required for scheduled posts")
+		}
+		if !blog.PublishAt.After(now) {
+			return invalidField("publish_at", "publish_at must be in the future for scheduled posts")
+		}
+	case models.BlogStatusPublished:
+		if blog.PublishAt == nil {
+			publishAt := normalizePublishTime(now)
+			blog.PublishAt = &publishAt
+		} else if blog.PublishAt.After(now) {
+			// Publishing with a future date is the same as scheduling it
+			blog.Status = models.BlogStatusScheduled
+		}
+	}
+
+	return nil
+}
+
+// PublishScheduledBlogs makes every scheduled post whose publish time has passed visible to readers. Their
+// updated_at moves on too, so feeds, the sitemap and ETags all see the change.
+func PublishScheduledBlogs() {
+	result, err := config.DB.Exec(
+		"UPDATE blogs SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE status = ? AND publish_at <= ?",
+		models.BlogStatusPublished, models.BlogStatusScheduled, normalizePublishTime(time.Now()),
+	)
+	if err != nil {
+		config.LogMessage("ERROR", "Error publishing scheduled blogs: "+err.Error())
+		return
+	}
+
+	if published, _ := result.RowsAffected(); published > 0 {
+		config.LogMessage("INFO", fmt.Sprintf("Published %d scheduled blog post(s)", published))
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxTagsPerBlog = 20
+
+// blogTagsColumn selects a post's tags as a comma separated list alongside the rest of its columns
+const blogTagsColumn = "(SELECT GROUP_CONCAT(t.name) FROM blog_tags bt JOIN tags t ON t.id = bt.tag_id WHERE bt.blog_id = blogs.id)"
+
+// NormalizeTaxonomyName turns a tag or category into the lowercase, hyphenated form used in URLs and storage
+func NormalizeTaxonomyName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ""
+	}
+	return Slugify(name)
+}
+
+// normalizeTags cleans up the tags given for a post, dropping blanks and duplicates
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		name := NormalizeTaxonomyName(tag)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		normalized = append(normalized, name)
+	}
+
+	if len(normalized) > maxTagsPerBlog {
+		return nil, fmt.Errorf("a post can have at most %d tags", maxTagsPerBlog)
+	}
+
+	return normalized, nil
+}
+
+// splitTags parses the value of blogTagsColumn
+func splitTags(list string) []string {
+	if list == "" {
+		return []string{}
+	}
+	return strings.Split(list, ",")
+}
+
+// setBlogTags replaces the tags attached to a post
+func setBlogTags(db querier, blogID int, tags []string) error {
+	if _, err := db.Exec("DELETE FROM blog_tags WHERE blog_id = ?", blogID); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if _, err := db.Exec("INSERT OR IGNORE INTO tags (name) VALUES (?)", tag); err != nil {
+			return err
+		}
+		_, err := db.Exec("INSERT INTO blog_tags (blog_id, tag_id) SELECT ?, id FROM tags WHERE name = ?", blogID, tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// blogListFilter builds the WHERE clause for listing posts visible to the caller, optionally narrowed by tag and category
+func blogListFilter(c *fiber.Ctx, tag string, category string) (string, []any) {
+	where := blogVisibilityFilter(c)
+	var args []any
+
+	if tag = NormalizeTaxonomyName(tag); tag != "" {
+		where += " AND id IN (SELECT bt.blog_id FROM blog_tags bt JOIN tags t ON t.id = bt.tag_id WHERE t.name = ?)"
+		args = append(args, tag)
+	}
+	if category = NormalizeTaxonomyName(category); category != "" {
+		where += " AND category = ?"
+		args = append(args, category)
+	}
+
+	return where, args
+}
+
+// getTagCounts returns every tag used by a post visible to the caller, with how many posts use it
+func getTagCounts(c *fiber.Ctx) ([]models.TagCount, error) {
+	rows, err := config.DB.Query(
+		"SELECT t.name, COUNT(*) FROM tags t JOIN blog_tags bt ON bt.tag_id = t.id JOIN blogs ON blogs.id = bt.blog_id WHERE " +
+			blogVisibilityFilter(c) + " GROUP BY t.name ORDER BY COUNT(*) DESC, t.name",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.TagCount{}
+	for rows.Next() {
+		var tag models.TagCount
+		if err := rows.Scan(&tag.Name, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// GetTags lists tags with their post counts
+func GetTags(c *fiber.Ctx) error {
+	tags, err := getTagCounts(c)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(tags)
+}
+
+// RenderTagPage renders every post with the given tag
+func RenderTagPage(c *fiber.Ctx) error {
+	tag := NormalizeTaxonomyName(c.Params("tag"))
+
+	where, args := blogListFilter(c, tag, "")
+	blogs, err := queryBlogs("WHERE "+where+" "+blogOrder, args...)
+	if err != nil {
+		fmt.Println("Error fetching blogs for tag:", err)
+		return fiber.ErrInternalServerError
+	}
+	if len(blogs) == 0 {
+		return fiber.ErrNotFound
+	}
+
+	return c.Render("blog/tag", fiber.Map{
+		"Title": "Posts tagged " + tag,
+		"Tag":   tag,
+		"Blogs": blogs,
+	}, "layout/base")
+}
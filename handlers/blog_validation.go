@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/models"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxTitleLength   = 200
+	maxAuthorLength  = 100
+	maxContentLength = 200000
+)
+
+// blogValidationError holds what is wrong with a post, keyed by the JSON name of each invalid field
+type blogValidationError map[string]string
+
+func (e blogValidationError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, field := range slices.Sorted(maps.Keys(e)) {
+		messages = append(messages, e[field])
+	}
+	return strings.Join(messages, "; ")
+}
+
+// invalidField reports a single invalid field of a post
+func invalidField(field string, format string, args ...any) error {
+	return blogValidationError{field: fmt.Sprintf(format, args...)}
+}
+
+// validateBlogFields checks the fields of a post that are stored as given
+func validateBlogFields(blog *models.Blog) error {
+	invalid := blogValidationError{}
+
+	if strings.TrimSpace(blog.Title) == "" {
+		invalid["title"] = "title is required"
+	} else if utf8.RuneCountInString(blog.Title) > maxTitleLength {
+		invalid["title"] = fmt.Sprintf("title must be at most %d characters", maxTitleLength)
+	}
+	if utf8.RuneCountInString(blog.Author) > maxAuthorLength {
+		invalid["author"] = fmt.Sprintf("author must be at most %d characters", maxAuthorLength)
+	}
+	if utf8.RuneCountInString(blog.Content) > maxContentLength {
+		invalid["content"] = fmt.Sprintf("content must be at most %d characters", maxContentLength)
+	}
+
+	if len(invalid) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+// prepareBlog validates and normalizes a post before it is saved, reporting every invalid field at once
+// rather than stopping at the first
+func prepareBlog(blog *models.Blog, now time.Time) error {
+	invalid := blogValidationError{}
+	for _, err := range []error{
+		validateBlogFields(blog),
+		prepareBlogContent(blog),
+		prepareBlogTaxonomy(blog),
+		prepareBlogSEO(blog),
+		prepareBlogSeries(blog),
+		prepareBlogStatus(blog, now),
+	} {
+		var fields blogValidationError
+		if errors.As(err, &fields) {
+			maps.Copy(invalid, fields)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(invalid) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+// blogErrorStatus maps an error saving a post onto the HTTP status to respond with
+func blogErrorStatus(err error) int {
+	var invalid blogValidationError
+	if errors.As(err, &invalid) {
+		return 400
+	}
+	if errors.Is(err, errBlogChanged) {
+		return 412
+	}
+	return slugErrorStatus(err)
+}
+
+// sendBlogError responds to a post that could not be saved, listing the invalid fields when there are any
+func sendBlogError(c *fiber.Ctx, err error) error {
+	response := fiber.Map{"error": err.Error()}
+
+	var invalid blogValidationError
+	if errors.As(err, &invalid) {
+		response["fields"] = invalid
+	} else if errors.Is(err, errSlugTaken) || errors.Is(err, errInvalidSlug) {
+		response["fields"] = blogValidationError{"slug": err.Error()}
+	}
+
+	return c.Status(blogErrorStatus(err)).JSON(response)
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/middleware"
+	"PersonalWebsiteGO/models"
+	"database/sql"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits on what a visitor can submit
+const (
+	maxCommentLength   = 5000
+	maxCommentNameLen  = 80
+	maxCommentEmailLen = 254
+	// Each IP address can leave commentRateLimit comments per commentRateWindow, an SQLite datetime modifier
+	commentRateLimit  = 5
+	commentRateWindow = "-10 minutes"
+)
+
+// maxModerationQueue caps how many comments one moderation request returns
+const maxModerationQueue = 200
+
+const commentColumns = "c.id, c.blog_id, b.title, c.parent_id, c.author_name, c.author_email, c.content, c.status, c.ip_address, c.created_at"
+
+// commentSubmittedMessage is sent for every accepted submission, including ones silently dropped as spam
+const commentSubmittedMessage = "Thanks! Your comment will appear once it has been approved"
+
+type commentRequest struct {
+	AuthorName  string `json:"author_name" form:"author_name"`
+	AuthorEmail string `json:"author_email" form:"author_email"`
+	Content     string `json:"content" form:"content"`
+	ParentID    *int   `json:"parent_id" form:"parent_id"`
+	// Website is a honeypot: the field is hidden from people, so only bots fill it in
+	Website string `json:"website" form:"website"`
+}
+
+type moderateCommentRequest struct {
+	Status string `json:"status"`
+}
+
+func scanComment(row rowScanner) (models.Comment, error) {
+	var comment models.Comment
+	var parentID sql.NullInt64
+	var blogTitle sql.NullString
+	err := row.Scan(&comment.ID, &comment.BlogID, &blogTitle, &parentID, &comment.AuthorName, &comment.AuthorEmail,
+		&comment.Content, &comment.Status, &comment.IPAddress, &comment.CreatedAt)
+	comment.BlogTitle = blogTitle.String
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		comment.ParentID = &id
+	}
+	return comment, err
+}
+
+// queryComments selects comments, joined to their post, using the given WHERE/ORDER BY clause
+func queryComments(clause string, args ...any) ([]models.Comment, error) {
+	rows, err := config.DB.Query("SELECT "+commentColumns+" FROM comments c LEFT JOIN blogs b ON b.id = c.blog_id "+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []models.Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
+
+// isValidModerationStatus reports whether status is one a moderator can give a comment
+func isValidModerationStatus(status string) bool {
+	switch status {
+	case models.CommentStatusApproved, models.CommentStatusRejected, models.CommentStatusSpam:
+		return true
+	}
+	return false
+}
+
+// getApprovedComments loads a post's approved comments as threads, oldest first, with
+// moderation details stripped so they are safe to show to readers
+func getApprovedComments(blogID int) ([]models.Comment, error) {
+	comments, err := queryComments("WHERE c.blog_id = ? AND c.status = ? ORDER BY c.created_at, c.id", blogID, models.CommentStatusApproved)
+	if err != nil {
+		return nil, err
+	}
+
+	threads := []models.Comment{}
+	position := map[int]int{}
+	for _, comment := range comments {
+		comment.BlogTitle = ""
+		comment.AuthorEmail = ""
+		comment.Status = ""
+		comment.IPAddress = ""
+
+		if comment.ParentID == nil {
+			position[comment.ID] = len(threads)
+			threads = append(threads, comment)
+		} else if i, ok := position[*comment.ParentID]; ok {
+			threads[i].Replies = append(threads[i].Replies, comment)
+		}
+		// Replies whose parent is no longer approved are hidden along with it
+	}
+
+	return threads, nil
+}
+
+// resolveCommentParent checks the comment being replied to belongs to the same post and is visible,
+// attaching replies to replies to the top of the thread so threads stay one level deep
+func resolveCommentParent(blogID int, parentID int) (int, error) {
+	var parentBlogID int
+	var grandparentID sql.NullInt64
+	var status string
+	err := config.DB.QueryRow("SELECT blog_id, parent_id, status FROM comments WHERE id = ?", parentID).
+		Scan(&parentBlogID, &grandparentID, &status)
+	if err == sql.ErrNoRows || (err == nil && (parentBlogID != blogID || status != models.CommentStatusApproved)) {
+		return 0, fmt.Errorf("The comment being replied to does not exist")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if grandparentID.Valid {
+		return int(grandparentID.Int64), nil
+	}
+	return parentID, nil
+}
+
+// validateCommentRequest trims the submission and checks it is within the limits
+func validateCommentRequest(req *commentRequest) error {
+	req.AuthorName = strings.TrimSpace(req.AuthorName)
+	req.AuthorEmail = strings.TrimSpace(req.AuthorEmail)
+	req.Content = strings.TrimSpace(req.Content)
+
+	if req.AuthorName == "" || req.Content == "" {
+		return fmt.Errorf("Name and comment are required")
+	}
+	if utf8.RuneCountInString(req.AuthorName) > maxCommentNameLen {
+		return fmt.Errorf("Name must be at most %d characters", maxCommentNameLen)
+	}
+	if utf8.RuneCountInString(req.Content) > maxCommentLength {
+		return fmt.Errorf("Comment must be at most %d characters", maxCommentLength)
+	}
+	if req.AuthorEmail != "" {
+		if len(req.AuthorEmail) > maxCommentEmailLen {
+			return fmt.Errorf("Email must be at most %d characters", maxCommentEmailLen)
+		}
+		if _, err := mail.ParseAddress(req.AuthorEmail); err != nil {
+			return fmt.Errorf("Invalid email address")
+		}
+	}
+	return nil
+}
+
+// GetBlogComments lists a published post's approved comments as threads
+func GetBlogComments(c *fiber.Ctx) error {
+	blogID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid blog ID"})
+	}
+
+	var exists int
+	err = config.DB.QueryRow("SELECT 1 FROM blogs WHERE id = ? AND "+blogVisibilityFilter(c), blogID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Blog not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	comments, err := getApprovedComments(blogID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(comments)
+}
+
+// CreateComment accepts a visitor's comment on a published post and queues it for moderation.
+// Comments from signed in editors and admins are approved straight away and are not rate limited.
+func CreateComment(c *fiber.Ctx) error {
+	blogID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid blog ID"})
+	}
+
+	var req commentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	ip := c.IP()
+
+	if req.Website != "" {
+		config.LogMessage("INFO", fmt.Sprintf("Discarded comment on blog %d from %s: honeypot field was filled in", blogID, ip))
+		return c.Status(201).JSON(fiber.Map{"message": commentSubmittedMessage, "status": models.CommentStatusPending})
+	}
+
+	if err := validateCommentRequest(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var exists int
+	err = config.DB.QueryRow("SELECT 1 FROM blogs WHERE id = ? AND status = ?", blogID, models.BlogStatusPublished).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Blog not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	identity, signedIn := middleware.OptionalUser(c)
+	trusted := signedIn && middleware.RoleAtLeast(identity.Role, models.RoleEditor)
+	if !trusted {
+		var recent int
+		err = config.DB.QueryRow(
+			"SELECT COUNT(*) FROM comments WHERE ip_address = ? AND created_at >= datetime('now', ?)", ip, commentRateWindow,
+		).Scan(&recent)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if recent >= commentRateLimit {
+			return c.Status(429).JSON(fiber.Map{"error": "Too many comments, please try again later"})
+		}
+	}
+
+	if req.ParentID != nil {
+		parentID, err := resolveCommentParent(blogID, *req.ParentID)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		req.ParentID = &parentID
+	}
+
+	status := models.CommentStatusPending
+	if trusted {
+		status = models.CommentStatusApproved
+	}
+
+	result, err := config.DB.Exec(
+		"INSERT INTO comments (blog_id, parent_id, author_name, author_email, content, status, ip_address) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		blogID, req.ParentID, req.AuthorName, req.AuthorEmail, req.Content, status, ip,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if status == models.CommentStatusPending {
+		id, _ := result.LastInsertId()
+		config.LogMessage("INFO", fmt.Sprintf("Comment %d on blog %d is awaiting moderation", id, blogID))
+		return c.Status(201).JSON(fiber.Map{"message": commentSubmittedMessage, "status": status})
+	}
+
+	return c.Status(201).JSON(fiber.Map{"message": "Comment posted", "status": status})
+}
+
+// GetComments lists comments for moderation, oldest first. ?status= picks the queue, defaulting to pending.
+func GetComments(c *fiber.Ctx) error {
+	status := c.Query("status", models.CommentStatusPending)
+	if status != models.CommentStatusPending && !isValidModerationStatus(status) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid status"})
+	}
+
+	comments, err := queryComments("WHERE c.status = ? ORDER BY c.created_at, c.id LIMIT ?", status, maxModerationQueue)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(comments)
+}
+
+// ModerateComment approves a comment, or rejects it or marks it as spam to keep it hidden
+func ModerateComment(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid comment ID"})
+	}
+
+	var req moderateCommentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if !isValidModerationStatus(req.Status) {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("status must be %q, %q or %q",
+			models.CommentStatusApproved, models.CommentStatusRejected, models.CommentStatusSpam)})
+	}
+
+	result, err := config.DB.Exec("UPDATE comments SET status = ? WHERE id = ?", req.Status, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Comment not found"})
+	}
+
+	comments, err := queryComments("WHERE c.id = ?", id)
+	if err != nil || len(comments) == 0 {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load comment"})
+	}
+
+	return c.JSON(comments[0])
+}
+
+// DeleteComment removes a comment along with any replies to it
+func DeleteComment(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid comment ID"})
+	}
+
+	result, err := config.DB.Exec("DELETE FROM comments WHERE id = ?", id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Comment not found"})
+	}
+
+	if _, err := config.DB.Exec("DELETE FROM comments WHERE parent_id = ?", id); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to remove replies to comment %d: %v", id, err))
+	}
+
+	return c.JSON(fiber.Map{"message": "Comment deleted successfully"})
+}
@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const feedItemLimit = 20
+
+// siteAuthor is who the site belongs to, from the SITE_AUTHOR environment variable. It names the site in link
+// previews and feeds, and feeds are credited to them, as are posts that name no author of their own.
+func siteAuthor() string {
+	if author := os.Getenv("SITE_AUTHOR"); author != "" {
+		return author
+	}
+	return "Ben Mercer"
+}
+
+// feedTitle is the title of the blog's feeds
+func feedTitle() string {
+	return siteAuthor() + "'s Blog"
+}
+
+// TemplateFuncs names the site for templates the same way the feeds and link previews do
+func TemplateFuncs() map[string]interface{} {
+	return map[string]interface{}{
+		"siteAuthor": siteAuthor,
+		"feedTitle":  feedTitle,
+	}
+}
+
+// feedDescription describes the blog in its feeds
+func feedDescription() string {
+	return "Project write-ups and notes from " + siteAuthor()
+}
+
+// blogAuthor is who a post is credited to in feeds
+func blogAuthor(blog models.Blog) string {
+	if blog.Author != "" {
+		return blog.Author
+	}
+	return siteAuthor()
+}
+
+// siteURL is the public address of the site used to build absolute links, without a trailing slash
+func siteURL() string {
+	if url := os.Getenv("SITE_URL"); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return "https://benjaminmercer.co.uk"
+}
+
+// blogURL is the absolute permalink of a post
+func blogURL(blog models.Blog) string {
+	return siteURL() + "/blog/" + blog.Slug
+}
+
+// blogFeedID is a tag URI identifying a post in feeds, which unlike its URL does not change when the slug does
+func blogFeedID(blog models.Blog) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(siteURL(), "https://"), "http://")
+	return fmt.Sprintf("tag:%s,%s:blog/%d", host, blog.CreatedAt.Format("2006-01-02"), blog.ID)
+}
+
+// blogLastModified is the latest time a post changed from a reader's point of view
+func blogLastModified(blog models.Blog) time.Time {
+	if blog.PostedAt().After(blog.UpdatedAt) {
+		return blog.PostedAt()
+	}
+	return blog.UpdatedAt
+}
+
+// getFeedBlogs returns the most recent published posts along with when any of them last changed
+func getFeedBlogs() ([]models.Blog, time.Time, error) {
+	blogs, err := queryBlogs("WHERE status = ? "+blogOrder+" LIMIT ?", models.BlogStatusPublished, feedItemLimit)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var lastModified time.Time
+	for _, blog := range blogs {
+		if modified := blogLastModified(blog); modified.After(lastModified) {
+			lastModified = modified
+		}
+	}
+
+	return blogs, lastModified, nil
+}
+
+// sendConditional sends a generated document with validators, answering 304 Not Modified when the client's copy is current
+func sendConditional(c *fiber.Ctx, body []byte, contentType string, lastModified time.Time) error {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	if !lastModified.IsZero() {
+		c.Set(fiber.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since when both are sent
+	if match := c.Get(fiber.HeaderIfNoneMatch); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return c.SendStatus(fiber.StatusNotModified)
+			}
+		}
+	} else if since := c.Get(fiber.HeaderIfModifiedSince); since != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(since); err == nil && !lastModified.Truncate(time.Second).After(t) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.Send(body)
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	DCNS      string     `xml:"xmlns:dc,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	SelfLink      rssLink   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"dc:creator"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"content:encoded"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// RSSFeed serves the blog as an RSS 2.0 feed at /feed.xml
+func RSSFeed(c *fiber.Ctx) error {
+	blogs, lastModified, err := getFeedBlogs()
+	if err != nil {
+		return c.Status(500).SendString("Error generating feed")
+	}
+
+	feed := rssFeed{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		DCNS:      "http://purl.org/dc/elements/1.1/",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		Channel: rssChannel{
+			Title:       feedTitle(),
+			Link:        siteURL() + "/projects/blogs",
+			Description: feedDescription(),
+			Language:    "en-gb",
+			SelfLink:    rssLink{Href: siteURL() + "/feed.xml", Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if !lastModified.IsZero() {
+		feed.Channel.LastBuildDate = lastModified.Format(time.RFC1123Z)
+	}
+
+	for _, blog := range blogs {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       blog.Title,
+			Link:        blogURL(blog),
+			GUID:        rssGUID{IsPermaLink: false, Value: blogFeedID(blog)},
+			PubDate:     blog.PostedAt().Format(time.RFC1123Z),
+			Creator:     blogAuthor(blog),
+			Categories:  blog.Tags,
+			Description: string(blog.ContentHTML),
+			Content:     string(blog.ContentHTML),
+		})
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return c.Status(500).SendString("Error generating feed")
+	}
+
+	return sendConditional(c, append([]byte(xml.Header), body...), "application/rss+xml; charset=utf-8", lastModified)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomAuthor     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// AtomFeed serves the blog as an Atom feed at /atom.xml
+func AtomFeed(c *fiber.Ctx) error {
+	blogs, lastModified, err := getFeedBlogs()
+	if err != nil {
+		return c.Status(500).SendString("Error generating feed")
+	}
+
+	// Atom needs an updated time even with no posts; a fixed one keeps the feed, and so its ETag, unchanged
+	updated := lastModified
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+
+	feed := atomFeed{
+		Title:   feedTitle(),
+		ID:      siteURL() + "/atom.xml",
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: siteURL() + "/atom.xml", Rel: "self", Type: "application/atom+xml"},
+			{Href: siteURL() + "/projects/blogs", Rel: "alternate", Type: "text/html"},
+		},
+		Author: atomAuthor{Name: siteAuthor()},
+	}
+
+	for _, blog := range blogs {
+		entry := atomEntry{
+			Title:     blog.Title,
+			ID:        blogFeedID(blog),
+			Link:      atomLink{Href: blogURL(blog), Rel: "alternate", Type: "text/html"},
+			Published: blog.PostedAt().UTC().Format(time.RFC3339),
+			Updated:   blogLastModified(blog).UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: blogAuthor(blog)},
+			Content:   atomContent{Type: "html", Value: string(blog.ContentHTML)},
+		}
+		for _, tag := range blog.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return c.Status(500).SendString("Error generating feed")
+	}
+
+	return sendConditional(c, append([]byte(xml.Header), body...), "application/atom+xml; charset=utf-8", lastModified)
+}
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Description string           `json:"description"`
+	Language    string           `json:"language"`
+	Authors     []jsonFeedAuthor `json:"authors"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+// JSONFeed serves the blog as a JSON Feed 1.1 document at /feed.json
+func JSONFeed(c *fiber.Ctx) error {
+	blogs, lastModified, err := getFeedBlogs()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feedTitle(),
+		HomePageURL: siteURL() + "/projects/blogs",
+		FeedURL:     siteURL() + "/feed.json",
+		Description: feedDescription(),
+		Language:    "en-GB",
+		Authors:     []jsonFeedAuthor{{Name: siteAuthor()}},
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, blog := range blogs {
+		feed.Items = append(feed.Items, jsonFeedItem{
+			ID:            blogFeedID(blog),
+			URL:           blogURL(blog),
+			Title:         blog.Title,
+			ContentHTML:   string(blog.ContentHTML),
+			DatePublished: blog.PostedAt().UTC().Format(time.RFC3339),
+			DateModified:  blogLastModified(blog).UTC().Format(time.RFC3339),
+			Authors:       []jsonFeedAuthor{{Name: blogAuthor(blog)}},
+			Tags:          blog.Tags,
+		})
+	}
+
+	body, err := json.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return sendConditional(c, body, "application/feed+json; charset=utf-8", lastModified)
+}
@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"testing"
+)
+
+// setupTestDatabase gives the test a fresh database in a temporary directory
+func setupTestDatabase(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := config.InitDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(config.CloseDatabase)
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits on failed sign ins. Once an IP address or username has used up its free attempts, each further
+// failure locks it out for twice as long as the last, from loginLockoutBase up to loginLockoutMax.
+const (
+	loginIPFreeAttempts = 5
+	// Usernames get more attempts than addresses, as anyone can fail to sign in as someone else to lock them out
+	loginUsernameFreeAttempts = 10
+	loginLockoutBase          = time.Minute
+	loginLockoutMax           = time.Hour
+	// Failures older than loginFailureWindow, an SQLite datetime modifier, are forgotten
+	loginFailureWindow = "-24 hours"
+	// maxLoginUsernameLength bounds what is stored for usernames that do not exist
+	maxLoginUsernameLength = 50
+)
+
+// loginAllowlist is the addresses and networks from the LOGIN_ALLOWLIST environment variable, a comma separated
+// list like "192.168.1.0/24, 10.0.0.5", that are never locked out, so the home network can always sign in
+var loginAllowlist = sync.OnceValue(func() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(os.Getenv("LOGIN_ALLOWLIST"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				config.LogMessage("ERROR", fmt.Sprintf("Ignoring invalid LOGIN_ALLOWLIST entry %q", entry))
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+})
+
+// isLoginAllowlisted reports whether an IP address is exempt from login lockouts
+func isLoginAllowlisted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range loginAllowlist() {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeLoginUsername is the form usernames are tracked in, matching how the users table ignores case
+func normalizeLoginUsername(username string) string {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if len(username) > maxLoginUsernameLength {
+		username = username[:maxLoginUsernameLength]
+	}
+	return username
+}
+
+// loginLockout is how long to lock out after the given number of recent failures
+func loginLockout(failures int, freeAttempts int) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+	lockout := loginLockoutBase
+	for range failures - freeAttempts {
+		lockout *= 2
+		if lockout >= loginLockoutMax {
+			return loginLockoutMax
+		}
+	}
+	return lockout
+}
+
+// loginFailures counts the recent failures matching the WHERE clause, and when the last of them was
+func loginFailures(clause string, args ...any) (int, time.Time, error) {
+	var count int
+	var last int64
+	err := config.DB.QueryRow(
+		"SELECT COUNT(*), COALESCE(CAST(strftime('%s', MAX(created_at)) AS INTEGER), 0) FROM login_failures "+
+			"WHERE created_at >= datetime('now', ?) AND "+clause,
+		append([]any{loginFailureWindow}, args...)...,
+	).Scan(&count, &last)
+	return count, time.Unix(last, 0), err
+}
+
+// loginLockedFor is how much longer sign ins from the IP address or as the username are locked out for, if at all
+func loginLockedFor(ip string, username string) (time.Duration, error) {
+	if isLoginAllowlisted(ip) {
+		return 0, nil
+	}
+
+	ipFailures, ipLast, err := loginFailures("ip_address = ?", ip)
+	if err != nil {
+		return 0, err
+	}
+	usernameFailures, usernameLast, err := loginFailures("username = ?", normalizeLoginUsername(username))
+	if err != nil {
+		return 0, err
+	}
+
+	wait := max(
+		time.Until(ipLast.Add(loginLockout(ipFailures, loginIPFreeAttempts))),
+		time.Until(usernameLast.Add(loginLockout(usernameFailures, loginUsernameFreeAttempts))),
+	)
+	return max(wait, 0), nil
+}
+
+// recordLoginFailure notes a failed sign in, logging the lockout it starts if it was one too many
+func recordLoginFailure(ip string, username string) error {
+	if isLoginAllowlisted(ip) {
+		return nil
+	}
+	username = normalizeLoginUsername(username)
+
+	if _, err := config.DB.Exec("DELETE FROM login_failures WHERE created_at < datetime('now', ?)", loginFailureWindow); err != nil {
+		return err
+	}
+	if _, err := config.DB.Exec("INSERT INTO login_failures (ip_address, username) VALUES (?, ?)", ip, username); err != nil {
+		return err
+	}
+
+	ipFailures, _, err := loginFailures("ip_address = ?", ip)
+	if err != nil {
+		return err
+	}
+	if lockout := loginLockout(ipFailures, loginIPFreeAttempts); lockout > 0 {
+		config.LogMessage("WARN", fmt.Sprintf("Login from %s locked for %s after %d failed attempts, the last as %q", ip, lockout, ipFailures, username))
+	}
+
+	usernameFailures, _, err := loginFailures("username = ?", username)
+	if err != nil {
+		return err
+	}
+	if lockout := loginLockout(usernameFailures, loginUsernameFreeAttempts); lockout > 0 {
+		config.LogMessage("WARN", fmt.Sprintf("Login as %q locked for %s after %d failed attempts, the last from %s", username, lockout, usernameFailures, ip))
+	}
+	return nil
+}
+
+// clearLoginFailures forgets the failures of an IP address signing in as a username once it gets it right.
+// Failures from other addresses still count, so a lockout someone else caused is not lifted by its target.
+func clearLoginFailures(ip string, username string) error {
+	_, err := config.DB.Exec("DELETE FROM login_failures WHERE ip_address = ? AND username = ?", ip, normalizeLoginUsername(username))
+	return err
+}
+
+// sendLoginLocked refuses a sign in during a lockout, telling the client when to try again
+func sendLoginLocked(c *fiber.Ctx, wait time.Duration) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, fmt.Sprint(seconds))
+	return c.Status(429).JSON(fiber.Map{
+		"error":       "Too many failed login attempts, please try again later",
+		"retry_after": seconds,
+	})
+}
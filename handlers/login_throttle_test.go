@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginLockoutDoubles(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{loginIPFreeAttempts - 1, 0},
+		{loginIPFreeAttempts, time.Minute},
+		{loginIPFreeAttempts + 1, 2 * time.Minute},
+		{loginIPFreeAttempts + 2, 4 * time.Minute},
+		{loginIPFreeAttempts + 5, 32 * time.Minute},
+		{loginIPFreeAttempts + 6, time.Hour},
+		{loginIPFreeAttempts + 100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := loginLockout(tt.failures, loginIPFreeAttempts); got != tt.want {
+			t.Errorf("loginLockout(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLockedFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		ip       string
+		username string
+		want     time.Duration
+	}{
+		{name: "free attempts left", failures: loginIPFreeAttempts - 1, ip: "192.0.2.1", username: "alice"},
+		{name: "free attempts used up", failures: loginIPFreeAttempts, ip: "192.0.2.1", username: "alice", want: time.Minute},
+		{name: "two more failures", failures: loginIPFreeAttempts + 2, ip: "192.0.2.1", username: "alice", want: 4 * time.Minute},
+		{name: "other address as the same user", failures: loginIPFreeAttempts + 2, ip: "192.0.2.2", username: "ALICE"},
+		{name: "same address as another user", failures: loginIPFreeAttempts + 2, ip: "192.0.2.1", username: "bob", want: 4 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDatabase(t)
+			for range tt.failures {
+				if err := recordLoginFailure("192.0.2.1", "alice"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := loginLockedFor(tt.ip, tt.username)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Failures are stored to the second, so the lockout may already have run for up to a second or two
+			if got > tt.want || got < tt.want-2*time.Second {
+				t.Errorf("loginLockedFor(%q, %q) = %s, want %s", tt.ip, tt.username, got, tt.want)
+			}
+		})
+	}
+}
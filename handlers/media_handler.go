@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	maxUploadSize   = 10 << 20
+	maxImagePixels  = 40_000_000
+	thumbnailSize   = 320
+	maxAltTextChars = 500
+)
+
+// MaxMediaRequestSize is the largest upload UploadMedia accepts: a file of up to maxUploadSize with room for
+// the rest of the form
+const MaxMediaRequestSize = maxUploadSize + 1<<20
+
+// Widths of the resized copies generated for each upload, smallest first
+var mediaVariantWidths = []int{640, 1280}
+
+// Image types that may be uploaded, keyed by sniffed MIME type, with the extension they are stored under
+var allowedMediaTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+const mediaColumns = "id, hash, file_name, mime_type, size, width, height, alt_text, created_at"
+
+// MediaDir is where uploaded files and their resized variants are stored
+func MediaDir() string {
+	if dir := os.Getenv("MEDIA_DIR"); dir != "" {
+		return dir
+	}
+	return "./uploads"
+}
+
+// mediaFileName is the stored name of an upload, or of one of its variants when suffix is set
+func mediaFileName(media models.Media, suffix string) string {
+	if suffix == "" {
+		return media.Hash + allowedMediaTypes[media.MimeType]
+	}
+	return media.Hash + "_" + suffix + variantExtension(media.MimeType)
+}
+
+// variantExtension picks the format resized copies are encoded in. Go has no WebP encoder, and
+// JPEG would lose the transparency PNG and GIF images may have.
+func variantExtension(mimeType string) string {
+	if mimeType == "image/png" || mimeType == "image/gif" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// mediaVariantSuffixes lists the variants generated for an image of the given width
+func mediaVariantSuffixes(width int) []string {
+	suffixes := []string{"thumb"}
+	for _, variantWidth := range mediaVariantWidths {
+		if width > variantWidth {
+			suffixes = append(suffixes, fmt.Sprintf("%dw", variantWidth))
+		}
+	}
+	return suffixes
+}
+
+// withURLs fills in the public URLs of an upload and its variants
+func withURLs(media models.Media) models.Media {
+	media.URL = "/media/" + mediaFileName(media, "")
+	media.ThumbnailURL = "/media/" + mediaFileName(media, "thumb")
+	media.Variants = make(map[string]string)
+	for _, suffix := range mediaVariantSuffixes(media.Width) {
+		if suffix != "thumb" {
+			media.Variants[suffix] = "/media/" + mediaFileName(media, suffix)
+		}
+	}
+	return media
+}
+
+func scanMedia(row rowScanner) (models.Media, error) {
+	var media models.Media
+	err := row.Scan(&media.ID, &media.Hash, &media.FileName, &media.MimeType, &media.Size, &media.Width, &media.Height, &media.AltText, &media.CreatedAt)
+	return withURLs(media), err
+}
+
+// resizeToFit scales an image down so it fits within maxWidth x maxHeight, keeping its aspect ratio
+func resizeToFit(src image.Image, maxWidth int, maxHeight int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxWidth && height <= maxHeight {
+		return src
+	}
+
+	scale := min(float64(maxWidth)/float64(width), float64(maxHeight)/float64(height))
+	newWidth := max(1, int(float64(width)*scale))
+	newHeight := max(1, int(float64(height)*scale))
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+// writeVariant encodes a resized copy of an image to the media directory
+func writeVariant(img image.Image, media models.Media, suffix string) error {
+	var buf bytes.Buffer
+	var err error
+	if variantExtension(media.MimeType) == ".png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(MediaDir(), mediaFileName(media, suffix)), buf.Bytes(), 0644)
+}
+
+// storeMedia writes an upload and its thumbnail and resized variants to disk
+func storeMedia(data []byte, media models.Media) error {
+	if err := os.MkdirAll(MediaDir(), 0755); err != nil {
+		return err
+	}
+
+	// Only the first frame of an animated GIF is used for the resized copies
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(MediaDir(), mediaFileName(media, "")), data, 0644); err != nil {
+		return err
+	}
+
+	if err := writeVariant(resizeToFit(img, thumbnailSize, thumbnailSize), media, "thumb"); err != nil {
+		return err
+	}
+	for _, variantWidth := range mediaVariantWidths {
+		if media.Width > variantWidth {
+			resized := resizeToFit(img, variantWidth, media.Height)
+			if err := writeVariant(resized, media, fmt.Sprintf("%dw", variantWidth)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reasons an upload is refused
+var (
+	errUnsupportedMedia = errors.New("Only JPEG, PNG, GIF and WebP images can be uploaded")
+	errInvalidImage     = errors.New("The file is not a valid image")
+	errImageTooLarge    = errors.New("The image dimensions are too large")
+)
+
+// mediaErrorStatus maps an error from saveMedia to the HTTP status it should be reported with
+func mediaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errUnsupportedMedia):
+		return 415
+	case errors.Is(err, errInvalidImage), errors.Is(err, errImageTooLarge):
+		return 400
+	}
+	return 500
+}
+
+// checkMedia validates an image, describing it without adding it to the library
+func checkMedia(data []byte) (models.Media, error) {
+	// Trust the file's contents rather than the name or Content-Type the client sent
+	mimeType := http.DetectContentType(data)
+	if _, ok := allowedMediaTypes[mimeType]; !ok {
+		return models.Media{}, errUnsupportedMedia
+	}
+
+	imageConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return models.Media{}, errInvalidImage
+	}
+	if imageConfig.Width*imageConfig.Height > maxImagePixels {
+		return models.Media{}, errImageTooLarge
+	}
+
+	sum := sha256.Sum256(data)
+	return models.Media{
+		Hash:     hex.EncodeToString(sum[:]),
+		MimeType: mimeType,
+		Size:     int64(len(data)),
+		Width:    imageConfig.Width,
+		Height:   imageConfig.Height,
+	}, nil
+}
+
+// saveMedia validates an image and adds it to the library, reporting whether it was new.
+// The same file saved twice shares one copy on disk.
+func saveMedia(db querier, data []byte, fileName string, altText string) (models.Media, bool, error) {
+	media, err := checkMedia(data)
+	if err != nil {
+		return models.Media{}, false, err
+	}
+
+	existing, err := scanMedia(db.QueryRow("SELECT "+mediaColumns+" FROM media WHERE hash = ?", media.Hash))
+	if err == nil {
+		return existing, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return models.Media{}, false, err
+	}
+
+	media.FileName = filepath.Base(fileName)
+	media.AltText = altText
+	media.CreatedAt = time.Now()
+
+	if err := storeMedia(data, media); err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to store upload %s: %v", media.FileName, err))
+		return models.Media{}, false, fmt.Errorf("Failed to store upload")
+	}
+
+	result, err := db.Exec(
+		"INSERT INTO media (hash, file_name, mime_type, size, width, height, alt_text, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		media.Hash, media.FileName, media.MimeType, media.Size, media.Width, media.Height, media.AltText, media.CreatedAt,
+	)
+	if err != nil {
+		return models.Media{}, false, err
+	}
+
+	id, _ := result.LastInsertId()
+	media.ID = int(id)
+
+	return withURLs(media), true, nil
+}
+
+// UploadMedia accepts an image as the multipart field "file", with optional "alt_text"
+func UploadMedia(c *fiber.Ctx) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "A file is required"})
+	}
+	if fileHeader.Size > maxUploadSize {
+		return c.Status(413).JSON(fiber.Map{"error": fmt.Sprintf("Files must be smaller than %d MB", maxUploadSize>>20)})
+	}
+
+	altText := strings.TrimSpace(c.FormValue("alt_text"))
+	if len([]rune(altText)) > maxAltTextChars {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("alt_text must be at most %d characters", maxAltTextChars)})
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Failed to read upload"})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxUploadSize+1))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Failed to read upload"})
+	}
+
+	media, created, err := saveMedia(config.DB, data, fileHeader.Filename, altText)
+	if err != nil {
+		return c.Status(mediaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !created {
+		return c.JSON(media)
+	}
+
+	return c.Status(201).JSON(media)
+}
+
+// GetAllMedia lists uploaded media, newest first
+func GetAllMedia(c *fiber.Ctx) error {
+	rows, err := config.DB.Query("SELECT " + mediaColumns + " FROM media ORDER BY created_at DESC")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+
+	media := []models.Media{}
+	for rows.Next() {
+		item, err := scanMedia(rows)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		media = append(media, item)
+	}
+
+	return c.JSON(media)
+}
+
+// mediaReferences lists the posts whose content refers to an upload or any of its variants
+func mediaReferences(hash string) ([]fiber.Map, error) {
+	rows, err := config.DB.Query("SELECT id, title FROM blogs WHERE instr(content, ?) > 0", hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	references := []fiber.Map{}
+	for rows.Next() {
+		var id int
+		var title string
+		if err := rows.Scan(&id, &title); err != nil {
+			return nil, err
+		}
+		references = append(references, fiber.Map{"id": id, "title": title})
+	}
+
+	return references, rows.Err()
+}
+
+// DeleteMedia deletes an upload and its variants, refusing while any post still uses it
+func DeleteMedia(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid media ID"})
+	}
+
+	media, err := scanMedia(config.DB.QueryRow("SELECT "+mediaColumns+" FROM media WHERE id = ?", id))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Media not found"})
+	}
+
+	references, err := mediaReferences(media.Hash)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(references) > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"error": "Media is still used by blog posts",
+			"blogs": references,
+		})
+	}
+
+	if _, err := config.DB.Exec("DELETE FROM media WHERE id = ?", id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	removeMediaFiles(media)
+
+	return c.JSON(fiber.Map{"message": "Media deleted successfully"})
+}
+
+// removeMediaFiles deletes an upload and its variants from disk
+func removeMediaFiles(media models.Media) {
+	suffixes := append([]string{""}, mediaVariantSuffixes(media.Width)...)
+	for _, suffix := range suffixes {
+		path := filepath.Join(MediaDir(), mediaFileName(media, suffix))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			config.LogMessage("ERROR", fmt.Sprintf("Failed to remove media file %s: %v", path, err))
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// mf2Item is a microformats2 item, such as the h-entry of a blog post or the h-card of its author
+type mf2Item struct {
+	types      []string
+	properties map[string][]mf2Value
+}
+
+// mf2Value is one value of a microformats2 property: text, or a nested item along with its text
+type mf2Value struct {
+	text string
+	item *mf2Item
+}
+
+// first returns the text of the property's first value
+func (i *mf2Item) first(property string) string {
+	if values := i.properties[property]; len(values) > 0 {
+		return values[0].text
+	}
+	return ""
+}
+
+// hasValue reports whether any value of the property is the given text, compared with match
+func (i *mf2Item) hasValue(property string, match func(string) bool) bool {
+	return slices.ContainsFunc(i.properties[property], func(value mf2Value) bool {
+		return match(value.text)
+	})
+}
+
+// nodeAttr returns the value of an element's attribute
+func nodeAttr(n *xhtml.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// mf2RootTypes lists the microformat types, like h-entry, an element's classes declare it to be
+func mf2RootTypes(n *xhtml.Node) []string {
+	var types []string
+	for _, class := range strings.Fields(nodeAttr(n, "class")) {
+		if strings.HasPrefix(class, "h-") && len(class) > 2 {
+			types = append(types, class)
+		}
+	}
+	return types
+}
+
+// mf2PropertyClasses lists the property classes, like p-name or u-url, on an element
+func mf2PropertyClasses(n *xhtml.Node) []string {
+	var properties []string
+	for _, class := range strings.Fields(nodeAttr(n, "class")) {
+		for _, prefix := range []string{"p-", "u-", "dt-", "e-"} {
+			if strings.HasPrefix(class, prefix) && len(class) > len(prefix) {
+				properties = append(properties, class)
+			}
+		}
+	}
+	return properties
+}
+
+// mf2Text is the text of an element with whitespace collapsed, using the alt text of images and skipping scripts
+func mf2Text(n *xhtml.Node) string {
+	var b strings.Builder
+	var walk func(*xhtml.Node)
+	walk = func(n *xhtml.Node) {
+		switch {
+		case n.Type == xhtml.TextNode:
+			b.WriteString(n.Data)
+		case n.Type == xhtml.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style):
+			return
+		case n.Type == xhtml.ElementNode && n.DataAtom == atom.Img:
+			b.WriteString(" " + nodeAttr(n, "alt") + " ")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// resolveURL makes a link found on a page absolute
+func resolveURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	parsed, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return base.ResolveReference(parsed).String()
+}
+
+// mf2LinkURL is the URL an element links to or embeds, if it is an element that does
+func mf2LinkURL(n *xhtml.Node, base *url.URL) string {
+	switch n.DataAtom {
+	case atom.A, atom.Area, atom.Link:
+		return resolveURL(base, nodeAttr(n, "href"))
+	case atom.Img, atom.Audio, atom.Video, atom.Source, atom.Iframe:
+		return resolveURL(base, nodeAttr(n, "src"))
+	case atom.Object:
+		return resolveURL(base, nodeAttr(n, "data"))
+	}
+	return ""
+}
+
+// mf2PropertyValue parses the value of a property from the element it is declared on, following the
+// microformats2 parsing rules for each kind of property
+func mf2PropertyValue(n *xhtml.Node, prefix string, base *url.URL) string {
+	switch prefix {
+	case "u-":
+		if link := mf2LinkURL(n, base); link != "" {
+			return link
+		}
+		if n.DataAtom == atom.Video && nodeAttr(n, "poster") != "" {
+			return resolveURL(base, nodeAttr(n, "poster"))
+		}
+		if n.DataAtom == atom.Abbr && nodeAttr(n, "title") != "" {
+			return nodeAttr(n, "title")
+		}
+		if value := nodeAttr(n, "value"); (n.DataAtom == atom.Data || n.DataAtom == atom.Input) && value != "" {
+			return value
+		}
+		return mf2Text(n)
+	case "dt-":
+		switch n.DataAtom {
+		case atom.Time, atom.Ins, atom.Del:
+			if datetime := nodeAttr(n, "datetime"); datetime != "" {
+				return datetime
+			}
+		case atom.Abbr:
+			if title := nodeAttr(n, "title"); title != "" {
+				return title
+			}
+		case atom.Data, atom.Input:
+			if value := nodeAttr(n, "value"); value != "" {
+				return value
+			}
+		}
+		return mf2Text(n)
+	}
+
+	switch n.DataAtom {
+	case atom.Abbr, atom.Link:
+		if title := nodeAttr(n, "title"); title != "" {
+			return title
+		}
+	case atom.Data, atom.Input:
+		if value := nodeAttr(n, "value"); value != "" {
+			return value
+		}
+	case atom.Img, atom.Area:
+		if alt := nodeAttr(n, "alt"); alt != "" {
+			return alt
+		}
+	}
+	return mf2Text(n)
+}
+
+// parseMF2Item parses the microformat rooted at an element
+func parseMF2Item(root *xhtml.Node, base *url.URL) *mf2Item {
+	item := &mf2Item{types: mf2RootTypes(root), properties: map[string][]mf2Value{}}
+	var hasText, hasURL, hasNested bool
+
+	var collect func(*xhtml.Node)
+	collect = func(n *xhtml.Node) {
+		if n.Type != xhtml.ElementNode {
+			return
+		}
+
+		properties := mf2PropertyClasses(n)
+		for _, class := range properties {
+			prefix, _, _ := strings.Cut(class, "-")
+			hasText = hasText || prefix == "p" || prefix == "e"
+			hasURL = hasURL || prefix == "u"
+		}
+
+		if len(mf2RootTypes(n)) > 0 {
+			// A nested microformat's properties belong to it rather than to this item
+			hasNested = true
+			nested := parseMF2Item(n, base)
+			for _, class := range properties {
+				prefix, name, _ := strings.Cut(class, "-")
+				value := mf2Value{item: nested, text: mf2PropertyValue(n, prefix+"-", base)}
+				if prefix == "p" && nested.first("name") != "" {
+					value.text = nested.first("name")
+				} else if prefix == "u" && nested.first("url") != "" {
+					value.text = nested.first("url")
+				}
+				item.properties[name] = append(item.properties[name], value)
+			}
+			return
+		}
+
+		for _, class := range properties {
+			prefix, name, _ := strings.Cut(class, "-")
+			item.properties[name] = append(item.properties[name], mf2Value{text: mf2PropertyValue(n, prefix+"-", base)})
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		collect(child)
+	}
+
+	// Items without explicit properties get a name, photo and url implied from their markup
+	if _, ok := item.properties["name"]; !ok && !hasText && !hasNested {
+		name := mf2Text(root)
+		if alt := nodeAttr(root, "alt"); (root.DataAtom == atom.Img || root.DataAtom == atom.Area) && alt != "" {
+			name = alt
+		} else if title := nodeAttr(root, "title"); root.DataAtom == atom.Abbr && title != "" {
+			name = title
+		}
+		item.properties["name"] = []mf2Value{{text: name}}
+	}
+	if _, ok := item.properties["photo"]; !ok && !hasURL {
+		if photo := mf2ImpliedLink(root, base, atom.Img); photo != "" {
+			item.properties["photo"] = []mf2Value{{text: photo}}
+		}
+	}
+	if _, ok := item.properties["url"]; !ok && !hasURL {
+		if link := mf2ImpliedLink(root, base, atom.A); link != "" {
+			item.properties["url"] = []mf2Value{{text: link}}
+		}
+	}
+
+	return item
+}
+
+// mf2ImpliedLink finds the implied photo or url of an item: the root element itself, or its only child
+// element, when that is an element of the given kind
+func mf2ImpliedLink(root *xhtml.Node, base *url.URL, kind atom.Atom) string {
+	if root.DataAtom == kind {
+		return mf2LinkURL(root, base)
+	}
+
+	var only *xhtml.Node
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != xhtml.ElementNode {
+			continue
+		}
+		if only != nil {
+			return ""
+		}
+		only = child
+	}
+	if only == nil || only.DataAtom != kind || len(mf2RootTypes(only)) > 0 {
+		return ""
+	}
+	return mf2LinkURL(only, base)
+}
+
+// findMF2Item parses the first microformat of the given type in a document
+func findMF2Item(doc *xhtml.Node, itemType string, base *url.URL) *mf2Item {
+	var found *mf2Item
+	var walk func(*xhtml.Node)
+	walk = func(n *xhtml.Node) {
+		if found != nil {
+			return
+		}
+		if n.Type == xhtml.ElementNode && slices.Contains(mf2RootTypes(n), itemType) {
+			found = parseMF2Item(n, base)
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return found
+}
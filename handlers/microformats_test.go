@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/models"
+	"net/url"
+	"strings"
+	"testing"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// parseTestHEntry finds the h-entry of an HTML page served from https://source.example/post
+func parseTestHEntry(t *testing.T, page string) *mf2Item {
+	t.Helper()
+	doc, err := xhtml.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := url.Parse("https://source.example/post")
+	entry := findMF2Item(doc, "h-entry", base)
+	if entry == nil {
+		t.Fatal("no h-entry found")
+	}
+	return entry
+}
+
+func TestFindMF2ItemHEntry(t *testing.T) {
+	entry := parseTestHEntry(t, `<html><body>
+		<nav class="h-card"><a class="p-name" href="/">Site nav</a></nav>
+		<article class="h-entry">
+			<div class="p-author h-card">
+				<img class="u-photo" src="/alice.jpg" alt="">
+				<a class="p-name u-url" href="https://alice.example/">Alice Example</a>
+			</div>
+			<div class="e-content">Thanks for <b>writing</b> this.</div>
+			<a class="u-url" href="/post">permalink</a>
+			<time class="dt-published" datetime="2024-05-01T10:00:00Z">May 1</time>
+		</article>
+	</body></html>`)
+
+	if got := entry.first("content"); got != "Thanks for writing this." {
+		t.Errorf("content = %q", got)
+	}
+	if got := entry.first("url"); got != "https://source.example/post" {
+		t.Errorf("url = %q", got)
+	}
+	if got := entry.first("published"); got != "2024-05-01T10:00:00Z" {
+		t.Errorf("published = %q", got)
+	}
+
+	authors := entry.properties["author"]
+	if len(authors) != 1 || authors[0].item == nil {
+		t.Fatalf("author = %+v, want one h-card", authors)
+	}
+	author := authors[0].item
+	if got := author.first("name"); got != "Alice Example" {
+		t.Errorf("author name = %q", got)
+	}
+	if got := author.first("url"); got != "https://alice.example/" {
+		t.Errorf("author url = %q", got)
+	}
+	if got := author.first("photo"); got != "https://source.example/alice.jpg" {
+		t.Errorf("author photo = %q", got)
+	}
+}
+
+func TestParseMF2ItemImpliedProperties(t *testing.T) {
+	doc, err := xhtml.Parse(strings.NewReader(`<a class="h-card" href="https://bob.example/"><img src="/bob.png" alt="Bob"></a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := url.Parse("https://source.example/")
+	card := findMF2Item(doc, "h-card", base)
+	if card == nil {
+		t.Fatal("no h-card found")
+	}
+
+	// The card is parsed again from its root to check parseMF2Item on its own
+	var root *xhtml.Node
+	var walk func(*xhtml.Node)
+	walk = func(n *xhtml.Node) {
+		if root == nil && nodeAttr(n, "class") == "h-card" {
+			root = n
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	card = parseMF2Item(root, base)
+
+	if got := card.first("url"); got != "https://bob.example/" {
+		t.Errorf("implied url = %q", got)
+	}
+	if got := card.first("photo"); got != "https://source.example/bob.png" {
+		t.Errorf("implied photo = %q", got)
+	}
+}
+
+func TestApplyHEntry(t *testing.T) {
+	tests := []struct {
+		name        string
+		page        string
+		wantType    string
+		wantAuthor  string
+		wantContent string
+	}{
+		{
+			name: "like",
+			page: `<div class="h-entry"><span class="p-author">Carol</span>
+				liked <a class="u-like-of" href="` + testWebmentionTarget + `">a post</a></div>`,
+			wantType:   models.WebmentionTypeLike,
+			wantAuthor: "Carol",
+		},
+		{
+			name: "reply",
+			page: `<div class="h-entry">
+				<a class="p-author h-card" href="https://dave.example">Dave</a>
+				<a class="u-in-reply-to" href="` + testWebmentionTarget + `/">In reply</a>
+				<p class="e-content">I disagree.</p></div>`,
+			wantType:    models.WebmentionTypeReply,
+			wantAuthor:  "Dave",
+			wantContent: "I disagree.",
+		},
+		{
+			name: "reply to another page is a mention",
+			page: `<div class="h-entry"><span class="p-author">Erin</span>
+				<a class="u-in-reply-to" href="https://elsewhere.example/">elsewhere</a>
+				<p class="p-summary">See also <a href="` + testWebmentionTarget + `">this</a></p></div>`,
+			wantType:    models.WebmentionTypeMention,
+			wantAuthor:  "Erin",
+			wantContent: "See also this",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mention := models.Webmention{Target: testWebmentionTarget, Type: models.WebmentionTypeMention}
+			applyHEntry(&mention, parseTestHEntry(t, tt.page))
+
+			if mention.Type != tt.wantType {
+				t.Errorf("type = %q, want %q", mention.Type, tt.wantType)
+			}
+			if mention.AuthorName != tt.wantAuthor {
+				t.Errorf("author = %q, want %q", mention.AuthorName, tt.wantAuthor)
+			}
+			if tt.wantContent != "" && mention.Content != tt.wantContent {
+				t.Errorf("content = %q, want %q", mention.Content, tt.wantContent)
+			}
+		})
+	}
+}
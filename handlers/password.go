@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters for new hashes, following the second recommended option of RFC 9106.
+// Stored hashes record the parameters they were made with, so raising these only affects new
+// passwords and ones rehashed when their owner next signs in.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// Limits on passwords people choose; the upper one bounds the work a login attempt can cause
+const (
+	minPasswordLength = 12
+	maxPasswordLength = 256
+)
+
+// temporaryPasswordBytes is how much randomness goes into a password generated by a reset
+const temporaryPasswordBytes = 18
+
+var errInvalidPasswordHash = errors.New("invalid password hash")
+
+// argon2Params are the settings a password hash was made with
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+var currentArgon2Params = argon2Params{time: argon2Time, memory: argon2Memory, threads: argon2Threads}
+
+// hashPassword hashes a password with Argon2id and a random salt, in the PHC string format
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	p := currentArgon2Params
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// decodePasswordHash splits a PHC string made by hashPassword into its parameters, salt and key
+func decodePasswordHash(encoded string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+	return p, salt, key, nil
+}
+
+// verifyPassword reports whether the password matches the hash, comparing the keys in constant time
+func verifyPassword(encoded string, password string) (bool, error) {
+	p, salt, key, err := decodePasswordHash(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, candidate) == 1, nil
+}
+
+// needsRehash reports whether a hash was made with weaker settings than new hashes use
+func needsRehash(encoded string) bool {
+	p, _, key, err := decodePasswordHash(encoded)
+	return err != nil || p != currentArgon2Params || len(key) != argon2KeyLen
+}
+
+// dummyPasswordHash is checked against when a login names an account that does not exist, so the
+// response takes as long as a wrong password would and does not reveal which usernames are taken
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword("not the password to any account")
+	return hash
+})
+
+// validateNewPassword checks a password someone has chosen is within the limits
+func validateNewPassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return fmt.Errorf("Password must be at least %d characters", minPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("Password must be at most %d bytes", maxPasswordLength)
+	}
+	return nil
+}
+
+// generateTemporaryPassword makes a random password for an account whose password has been reset
+func generateTemporaryPassword() (string, error) {
+	b := make([]byte, temporaryPasswordBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
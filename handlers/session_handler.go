@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/middleware"
+	"database/sql"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetSessions lists the devices the signed in user is signed in on
+func GetSessions(c *fiber.Ctx) error {
+	identity, _ := middleware.CurrentUser(c)
+	sessions, err := middleware.GetUserSessions(identity.UserID, identity.SessionID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(sessions)
+}
+
+// RevokeSession signs the signed in user out of one of their sessions, such as on a lost device
+func RevokeSession(c *fiber.Ctx) error {
+	identity, _ := middleware.CurrentUser(c)
+	revoked, err := middleware.RevokeSession(identity.UserID, c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !revoked {
+		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("User %s ended one of their sessions", identity.Username))
+	return c.JSON(fiber.Map{"message": "Session revoked successfully"})
+}
+
+// RevokeAllSessions signs the signed in user out everywhere, including the session making the request
+func RevokeAllSessions(c *fiber.Ctx) error {
+	identity, _ := middleware.CurrentUser(c)
+	revoked, err := middleware.RevokeUserSessions(identity.UserID, "")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("User %s ended all %d of their sessions", identity.Username, revoked))
+	return c.JSON(fiber.Map{"message": "All sessions revoked successfully", "revoked": revoked})
+}
+
+// GetUserSessions lists the devices any user is signed in on
+func GetUserSessions(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	identity, _ := middleware.CurrentUser(c)
+	sessions, err := middleware.GetUserSessions(id, identity.SessionID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(sessions)
+}
+
+// RevokeUserSessions signs any user out everywhere
+func RevokeUserSessions(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	user, err := scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	revoked, err := middleware.RevokeUserSessions(user.ID, "")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("All %d sessions of user %s ended by %s", revoked, user.Username, middleware.CurrentUsername(c)))
+	return c.JSON(fiber.Map{"message": "All sessions revoked successfully", "revoked": revoked})
+}
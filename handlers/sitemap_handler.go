@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxSitemapURLs is the most URLs the sitemap protocol allows in one file. Past this /sitemap.xml becomes
+// an index of numbered sitemaps.
+const maxSitemapURLs = 50000
+
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// defaultRobotsDisallow keeps crawlers out of the API and admin pages unless ROBOTS_DISALLOW says otherwise
+const defaultRobotsDisallow = "/logs,/api/"
+
+// sitemapPages holds the paths of the site's fixed pages, filled in once every route has been registered
+var sitemapPages []string
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// sitemapEntry is one page listed in the sitemap
+type sitemapEntry struct {
+	path    string
+	lastMod time.Time
+}
+
+// robotsDisallow lists the path prefixes crawlers are asked to stay out of, from the comma separated
+// ROBOTS_DISALLOW environment variable
+func robotsDisallow() []string {
+	value, ok := os.LookupEnv("ROBOTS_DISALLOW")
+	if !ok {
+		value = defaultRobotsDisallow
+	}
+
+	var paths []string
+	for _, path := range strings.Split(value, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// isRobotsDisallowed reports whether robots.txt asks crawlers to skip path
+func isRobotsDisallowed(path string) bool {
+	for _, prefix := range robotsDisallow() {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSitemapRoutes picks the fixed pages to list in the sitemap out of the app's routes: GET routes
+// without parameters that are not files or feeds, that robots.txt does not exclude and that are not in skip
+func SetSitemapRoutes(routes []fiber.Route, skip ...string) {
+	var pages []string
+	for _, route := range routes {
+		path := route.Path
+		if route.Method != fiber.MethodGet || strings.ContainsAny(path, ":*.") || isRobotsDisallowed(path) || slices.Contains(skip, path) {
+			continue
+		}
+		if !slices.Contains(pages, path) {
+			pages = append(pages, path)
+		}
+	}
+
+	sort.Strings(pages)
+	sitemapPages = pages
+}
+
+// getSitemapEntries lists every page for the sitemap: the fixed pages, then each published post and tag page
+func getSitemapEntries() ([]sitemapEntry, time.Time, error) {
+	entries := []sitemapEntry{}
+	for _, path := range sitemapPages {
+		entries = append(entries, sitemapEntry{path: path})
+	}
+
+	rows, err := config.DB.Query(
+		"SELECT slug, publish_at, created_at, updated_at, "+blogTagsColumn+" FROM blogs WHERE status = ? ORDER BY id",
+		models.BlogStatusPublished,
+	)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var lastModified time.Time
+	tagsModified := map[string]time.Time{}
+	for rows.Next() {
+		var blog models.Blog
+		var publishAt sql.NullTime
+		var tags sql.NullString
+		if err := rows.Scan(&blog.Slug, &publishAt, &blog.CreatedAt, &blog.UpdatedAt, &tags); err != nil {
+			return nil, time.Time{}, err
+		}
+		if publishAt.Valid {
+			blog.PublishAt = &publishAt.Time
+		}
+
+		modified := blogLastModified(blog)
+		entries = append(entries, sitemapEntry{path: "/blog/" + blog.Slug, lastMod: modified})
+		if modified.After(lastModified) {
+			lastModified = modified
+		}
+
+		for _, tag := range splitTags(tags.String) {
+			if modified.After(tagsModified[tag]) {
+				tagsModified[tag] = modified
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	tags := make([]string, 0, len(tagsModified))
+	for tag := range tagsModified {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		entries = append(entries, sitemapEntry{path: "/blog/tag/" + tag, lastMod: tagsModified[tag]})
+	}
+
+	return entries, lastModified, nil
+}
+
+// sitemapLastMod formats a time as the W3C datetime sitemaps use
+func sitemapLastMod(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// sendSitemapURLs sends a set of pages as a sitemap
+func sendSitemapURLs(c *fiber.Ctx, entries []sitemapEntry, lastModified time.Time) error {
+	urlSet := sitemapURLSet{Xmlns: sitemapNS}
+	for _, entry := range entries {
+		urlSet.URLs = append(urlSet.URLs, sitemapURL{Loc: siteURL() + entry.path, LastMod: sitemapLastMod(entry.lastMod)})
+	}
+
+	body, err := xml.MarshalIndent(urlSet, "", "  ")
+	if err != nil {
+		return c.Status(500).SendString("Error generating sitemap")
+	}
+
+	return sendConditional(c, append([]byte(xml.Header), body...), "application/xml; charset=utf-8", lastModified)
+}
+
+// Sitemap serves /sitemap.xml, which lists every page directly, or once there are too many for one file,
+// an index pointing at /sitemap-1.xml, /sitemap-2.xml and so on
+func Sitemap(c *fiber.Ctx) error {
+	entries, lastModified, err := getSitemapEntries()
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to generate sitemap: %v", err))
+		return c.Status(500).SendString("Error generating sitemap")
+	}
+
+	if len(entries) <= maxSitemapURLs {
+		return sendSitemapURLs(c, entries, lastModified)
+	}
+
+	index := sitemapIndex{Xmlns: sitemapNS}
+	for page := 1; (page-1)*maxSitemapURLs < len(entries); page++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{
+			Loc:     fmt.Sprintf("%s/sitemap-%d.xml", siteURL(), page),
+			LastMod: sitemapLastMod(lastModified),
+		})
+	}
+
+	body, err := xml.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return c.Status(500).SendString("Error generating sitemap")
+	}
+
+	return sendConditional(c, append([]byte(xml.Header), body...), "application/xml; charset=utf-8", lastModified)
+}
+
+// SitemapPage serves one of the numbered sitemaps listed in the sitemap index
+func SitemapPage(c *fiber.Ctx) error {
+	page, err := c.ParamsInt("page")
+	if err != nil || page < 1 {
+		return fiber.ErrNotFound
+	}
+
+	entries, lastModified, err := getSitemapEntries()
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to generate sitemap: %v", err))
+		return c.Status(500).SendString("Error generating sitemap")
+	}
+
+	start := (page - 1) * maxSitemapURLs
+	if start >= len(entries) {
+		return fiber.ErrNotFound
+	}
+
+	return sendSitemapURLs(c, entries[start:min(start+maxSitemapURLs, len(entries))], lastModified)
+}
+
+// RobotsTxt serves /robots.txt, excluding the paths in ROBOTS_DISALLOW and pointing crawlers at the sitemap
+func RobotsTxt(c *fiber.Ctx) error {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+
+	disallow := robotsDisallow()
+	if len(disallow) == 0 {
+		b.WriteString("Disallow:\n")
+	}
+	for _, path := range disallow {
+		b.WriteString("Disallow: " + path + "\n")
+	}
+
+	b.WriteString("\nSitemap: " + siteURL() + "/sitemap.xml\n")
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+	c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+	return c.SendString(b.String())
+}
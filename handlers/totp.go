@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// TOTP settings from RFC 6238. These are the defaults every authenticator app supports, so the
+// otpauth URI spells them out only for completeness.
+const (
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSecretSize = 20
+	// totpSkew is how many periods either side of now a code is still accepted in, allowing for clock drift
+	totpSkew = 1
+	// totpQRCodeSize is the width and height in pixels of the enrollment QR code
+	totpQRCodeSize = 256
+)
+
+// Recovery codes are long enough that a plain SHA-256 of them cannot be brute forced
+const (
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret makes a random shared secret, base32 encoded as authenticator apps expect
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode is the code for a secret in the given period, as described by RFC 4226 and RFC 6238
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulus := uint32(1)
+	for range totpDigits {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulus), nil
+}
+
+// verifyTOTP checks a code against a secret, returning the period it matched. Codes from the period last
+// used or earlier are refused, so a code seen by someone looking over a shoulder cannot be used again.
+func verifyTOTP(secret string, code string, lastStep int64, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpIssuer names the site in authenticator apps
+func totpIssuer() string {
+	if parsed, err := url.Parse(siteURL()); err == nil && parsed.Hostname() != "" {
+		return parsed.Hostname()
+	}
+	return feedTitle()
+}
+
+// totpURI is the otpauth URI authenticator apps enroll a secret from
+func totpURI(username string, secret string) string {
+	issuer := totpIssuer()
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(username)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// totpQRCode renders an otpauth URI as a PNG QR code, as a data URI that can go straight into an img tag
+func totpQRCode(uri string) (string, error) {
+	png, err := qrcode.Encode(uri, qrcode.Medium, totpQRCodeSize)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+// generateRecoveryCode makes a one-time code for signing in without the authenticator, like ABCD-EFGH-IJKL-MNOP
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	encoded := totpEncoding.EncodeToString(b)
+	var groups []string
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:min(i+4, len(encoded))])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// hashRecoveryCode is what a recovery code is stored as, ignoring case and the dashes between groups
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// The RFC's codes are 8 digits long; the 6 digit codes authenticator apps show are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatalf("totpCode at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode at %d = %q, want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current code", code: "050471", wantStep: current, wantOK: true},
+		{name: "spaces are ignored", code: " 050 471 ", wantStep: current, wantOK: true},
+		{name: "previous period allowed for drift", code: "081804", wantStep: current - 1, wantOK: true},
+		{name: "wrong code", code: "123456"},
+		{name: "wrong length", code: "05047"},
+		{name: "code already used", code: "050471", lastStep: current},
+		{name: "earlier code after a later one was used", code: "081804", lastStep: current},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := verifyTOTP(rfc6238Secret, tt.code, tt.lastStep, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("verifyTOTP(%q) = %d, %v, want %d, %v", tt.code, step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
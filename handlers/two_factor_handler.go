@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/middleware"
+	"PersonalWebsiteGO/models"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type disableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type loginTwoFactorRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// isTOTPCode reports whether a code is shaped like one from an authenticator app rather than a recovery code
+func isTOTPCode(code string) bool {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	return len(code) == totpDigits && strings.Trim(code, "0123456789") == ""
+}
+
+// checkTOTP checks a code from the user's authenticator app against the secret stored for them, enabled or
+// still being enrolled, and records the period it was for so it cannot be used again
+func checkTOTP(userID int, code string) (bool, error) {
+	var secret string
+	var lastStep int64
+	err := config.DB.QueryRow("SELECT totp_secret, totp_last_step FROM users WHERE id = ?", userID).Scan(&secret, &lastStep)
+	if err != nil {
+		return false, err
+	}
+	if secret == "" {
+		return false, nil
+	}
+
+	step, ok := verifyTOTP(secret, code, lastStep, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// Only one request can move the last step past this one, so a code racing itself is only accepted once
+	result, err := config.DB.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	return updated > 0, err
+}
+
+// useRecoveryCode spends one of the user's recovery codes, reporting whether it was one they had left
+func useRecoveryCode(user models.User, code string) (bool, error) {
+	result, err := config.DB.Exec(
+		"UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		user.ID, hashRecoveryCode(code),
+	)
+	if err != nil {
+		return false, err
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		return false, err
+	}
+
+	remaining, err := countRecoveryCodes(user.ID)
+	if err != nil {
+		return false, err
+	}
+	config.LogMessage("INFO", fmt.Sprintf("User %s signed in with a recovery code; %d left", user.Username, remaining))
+	return true, nil
+}
+
+// verifySecondFactor checks a code from the user's authenticator app, or one of their recovery codes
+func verifySecondFactor(user models.User, code string) (bool, error) {
+	if isTOTPCode(code) {
+		return checkTOTP(user.ID, code)
+	}
+	return useRecoveryCode(user, code)
+}
+
+// countRecoveryCodes is how many unused recovery codes the user has left
+func countRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := config.DB.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// replaceRecoveryCodes gives the user a fresh set of recovery codes, invalidating any they had before.
+// Only hashes are stored, so the codes returned are the only time they can be seen.
+func replaceRecoveryCodes(userID int) ([]string, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashRecoveryCode(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, tx.Commit()
+}
+
+// clearTwoFactor turns off the user's second factor and forgets their secret and recovery codes
+func clearTwoFactor(userID int) error {
+	_, err := config.DB.Exec(
+		"UPDATE users SET totp_secret = '', totp_enabled = 0, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP WHERE id = ?", userID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = config.DB.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	return err
+}
+
+// currentUser loads the account of the signed in user
+func currentUser(c *fiber.Ctx) (models.User, error) {
+	identity, _ := middleware.CurrentUser(c)
+	return scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", identity.UserID))
+}
+
+// LoginTwoFactor finishes signing in a user with two-factor authentication, taking the challenge Login
+// gave them for their password along with a code from their authenticator app or a recovery code
+func LoginTwoFactor(c *fiber.Ctx) error {
+	var req loginTwoFactorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	userID, err := middleware.ParseChallengeToken(req.Challenge)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Sign in has expired, please enter your password again"})
+	}
+	user, err := scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", userID))
+	if err != nil || !user.TwoFactorEnabled {
+		return c.Status(401).JSON(fiber.Map{"error": "Sign in has expired, please enter your password again"})
+	}
+
+	// Wrong codes count towards the same lockouts as wrong passwords, so codes cannot be guessed either
+	wait, err := loginLockedFor(c.IP(), user.Username)
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to check login attempts: %v", err))
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check code"})
+	}
+	if wait > 0 {
+		return sendLoginLocked(c, wait)
+	}
+
+	ok, err := verifySecondFactor(user, req.Code)
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to check second factor of user %s: %v", user.Username, err))
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check code"})
+	}
+	if !ok {
+		if err := recordLoginFailure(c.IP(), user.Username); err != nil {
+			config.LogMessage("ERROR", fmt.Sprintf("Failed to record login attempt: %v", err))
+		}
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid authentication code"})
+	}
+
+	return sendNewSession(c, user)
+}
+
+// GetTwoFactorStatus reports whether the signed in user has two-factor authentication turned on
+func GetTwoFactorStatus(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	remaining, err := countRecoveryCodes(user.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{
+		"enabled":                  user.TwoFactorEnabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// SetupTwoFactor starts enrolling the signed in user in two-factor authentication, returning a new secret
+// for their authenticator app as text, an otpauth URI and a QR code. It is not turned on until
+// EnableTwoFactor confirms the app is producing the right codes.
+func SetupTwoFactor(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user.TwoFactorEnabled {
+		return c.Status(409).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate secret"})
+	}
+	uri := totpURI(user.Username, secret)
+	qrCode, err := totpQRCode(uri)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate QR code"})
+	}
+
+	_, err = config.DB.Exec("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?", secret, user.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"qr_code":     qrCode,
+	})
+}
+
+// EnableTwoFactor turns on two-factor authentication for the signed in user once they have entered a code
+// from the authenticator app they set up, returning their recovery codes
+func EnableTwoFactor(c *fiber.Ctx) error {
+	var req twoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user.TwoFactorEnabled {
+		return c.Status(409).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+
+	ok, err := checkTOTP(user.ID, req.Code)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid authentication code; set up two-factor authentication first if you have not"})
+	}
+
+	codes, err := replaceRecoveryCodes(user.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := config.DB.Exec("UPDATE users SET totp_enabled = 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", user.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("User %s enabled two-factor authentication", user.Username))
+	return c.JSON(fiber.Map{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns off two-factor authentication for the signed in user, who has to confirm both
+// their password and a code
+func DisableTwoFactor(c *fiber.Ctx) error {
+	var req disableTwoFactorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	user, ok, err := authenticateUser(middleware.CurrentUsername(c), req.Password)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check credentials"})
+	}
+	if !ok {
+		return c.Status(403).JSON(fiber.Map{"error": "Password is incorrect"})
+	}
+	if !user.TwoFactorEnabled {
+		return c.Status(400).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+	}
+
+	ok, err = verifySecondFactor(user, req.Code)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(403).JSON(fiber.Map{"error": "Invalid authentication code"})
+	}
+
+	if err := clearTwoFactor(user.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("User %s disabled two-factor authentication", user.Username))
+	return c.JSON(fiber.Map{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the signed in user's recovery codes, for when they have used or lost them
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var req twoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !user.TwoFactorEnabled {
+		return c.Status(400).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+	}
+
+	ok, err := checkTOTP(user.ID, req.Code)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(403).JSON(fiber.Map{"error": "Invalid authentication code"})
+	}
+
+	codes, err := replaceRecoveryCodes(user.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("User %s generated new recovery codes", user.Username))
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// ResetUserTwoFactor turns off two-factor authentication for any user, such as one who has lost both their
+// authenticator and recovery codes, and signs them out everywhere
+func ResetUserTwoFactor(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	user, err := scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := clearTwoFactor(user.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := signOutUser(user); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("Two-factor authentication of user %s reset by %s", user.Username, middleware.CurrentUsername(c)))
+	return c.JSON(fiber.Map{"message": "Two-factor authentication reset successfully"})
+}
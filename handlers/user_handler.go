@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/middleware"
+	"PersonalWebsiteGO/models"
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const userColumns = "id, username, role, password_hash, must_change_password, totp_enabled, created_at, updated_at, password_changed_at"
+
+// validUsername keeps usernames to characters that are safe to show and type anywhere
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9._-]{3,50}$`)
+
+type createUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+type updateUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func scanUser(row rowScanner) (models.User, error) {
+	var user models.User
+	err := row.Scan(&user.ID, &user.Username, &user.Role, &user.PasswordHash, &user.MustChangePassword,
+		&user.TwoFactorEnabled, &user.CreatedAt, &user.UpdatedAt, &user.PasswordChangedAt)
+	return user, err
+}
+
+// queryUsers selects users using the given WHERE/ORDER BY clause
+func queryUsers(clause string, args ...any) ([]models.User, error) {
+	rows, err := config.DB.Query("SELECT "+userColumns+" FROM users "+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// getUserByUsername looks up an account by its username, ignoring case
+func getUserByUsername(username string) (models.User, error) {
+	return scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+// setUserPassword stores a new password hash for an account. A password set by someone else
+// has to be changed by the account's owner the next time they sign in.
+func setUserPassword(id int, hash string, mustChange bool) error {
+	_, err := config.DB.Exec(`UPDATE users SET password_hash = ?, must_change_password = ?,
+		updated_at = CURRENT_TIMESTAMP, password_changed_at = CURRENT_TIMESTAMP WHERE id = ?`, hash, mustChange, id)
+	return err
+}
+
+// authenticateUser checks a username and password, returning the account they belong to.
+// Unknown usernames cost the same as wrong passwords so the two cannot be told apart.
+func authenticateUser(username string, password string) (models.User, bool, error) {
+	if len(password) > maxPasswordLength {
+		return models.User{}, false, nil
+	}
+
+	user, err := getUserByUsername(username)
+	if err == sql.ErrNoRows {
+		verifyPassword(dummyPasswordHash(), password)
+		return models.User{}, false, nil
+	}
+	if err != nil {
+		return models.User{}, false, err
+	}
+
+	ok, err := verifyPassword(user.PasswordHash, password)
+	if err != nil {
+		return models.User{}, false, fmt.Errorf("password hash of user %d: %w", user.ID, err)
+	}
+	if !ok {
+		return models.User{}, false, nil
+	}
+
+	// Bring hashes made with older settings up to date while the password is at hand
+	if needsRehash(user.PasswordHash) {
+		if hash, err := hashPassword(password); err == nil {
+			if _, err := config.DB.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hash, user.ID); err != nil {
+				config.LogMessage("ERROR", fmt.Sprintf("Failed to rehash password of user %s: %v", user.Username, err))
+			}
+		}
+	}
+
+	return user, true, nil
+}
+
+// BootstrapAdminUser creates the first account from the ADMIN_USERNAME and ADMIN_PASSWORD environment
+// variables the site used to sign in with. It only does anything while there are no accounts, so once
+// it has run the variables are no longer read and can be removed.
+func BootstrapAdminUser() error {
+	var count int
+	if err := config.DB.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	username := strings.TrimSpace(os.Getenv("ADMIN_USERNAME"))
+	password := os.Getenv("ADMIN_PASSWORD")
+	if username == "" || password == "" {
+		config.LogMessage("ERROR", "No user accounts exist and ADMIN_USERNAME/ADMIN_PASSWORD are not set, so nobody can sign in")
+		return nil
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = config.DB.Exec("INSERT INTO users (username, role, password_hash) VALUES (?, ?, ?)", username, models.RoleAdmin, hash)
+	if err != nil {
+		return err
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("Created admin user %s from ADMIN_USERNAME; ADMIN_PASSWORD is no longer used and can be removed", username))
+	return nil
+}
+
+// GetUsers lists the accounts that can sign in
+func GetUsers(c *fiber.Ctx) error {
+	users, err := queryUsers("ORDER BY username COLLATE NOCASE")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(users)
+}
+
+// CreateUser adds an account with the password and role given, defaulting to a viewer
+func CreateUser(c *fiber.Ctx) error {
+	var req createUserRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if !validUsername.MatchString(req.Username) {
+		return c.Status(400).JSON(fiber.Map{"error": "Username must be 3 to 50 letters, digits, dots, dashes or underscores"})
+	}
+	if err := validateNewPassword(req.Password); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.Role == "" {
+		req.Role = models.RoleViewer
+	}
+	if !models.IsValidRole(req.Role) {
+		return c.Status(400).JSON(fiber.Map{"error": "Role must be admin, editor or viewer"})
+	}
+
+	if _, err := getUserByUsername(req.Username); err == nil {
+		return c.Status(409).JSON(fiber.Map{"error": "Username is already taken"})
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to hash password"})
+	}
+	result, err := config.DB.Exec("INSERT INTO users (username, role, password_hash) VALUES (?, ?, ?)", req.Username, req.Role, hash)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	id, _ := result.LastInsertId()
+
+	user, err := scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("User %s created as %s by %s", user.Username, user.Role, middleware.CurrentUsername(c)))
+	return c.Status(201).JSON(user)
+}
+
+// signOutUser signs a user out everywhere, after something that changes who they are or how they sign in
+func signOutUser(user models.User) error {
+	ended, err := middleware.RevokeUserSessions(user.ID, "")
+	if err != nil {
+		return err
+	}
+	if ended > 0 {
+		config.LogMessage("INFO", fmt.Sprintf("Ended %d sessions of user %s", ended, user.Username))
+	}
+	return nil
+}
+
+// ResetUserPassword replaces an account's password with a random temporary one, returned once in the
+// response for passing on to its owner, who has to choose a new password when they next sign in
+func ResetUserPassword(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	user, err := scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	password, err := generateTemporaryPassword()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate password"})
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to hash password"})
+	}
+	if err := setUserPassword(user.ID, hash, true); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := signOutUser(user); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("Password of user %s reset by %s", user.Username, middleware.CurrentUsername(c)))
+	return c.JSON(fiber.Map{
+		"message":  "Password reset; it must be changed at the next sign in",
+		"password": password,
+	})
+}
+
+// UpdateUserRole changes what an account is allowed to do. Admins cannot change their own role,
+// so there is always an admin left to manage the site. The user is signed out, and gets the new role when they sign in again.
+func UpdateUserRole(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var req updateUserRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if !models.IsValidRole(req.Role) {
+		return c.Status(400).JSON(fiber.Map{"error": "Role must be admin, editor or viewer"})
+	}
+
+	user, err := scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if identity, _ := middleware.CurrentUser(c); identity.UserID == user.ID {
+		return c.Status(400).JSON(fiber.Map{"error": "You cannot change your own role"})
+	}
+
+	_, err = config.DB.Exec("UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", req.Role, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := signOutUser(user); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("User %s changed from %s to %s by %s", user.Username, user.Role, req.Role, middleware.CurrentUsername(c)))
+	return c.JSON(fiber.Map{"message": "Role updated successfully"})
+}
+
+// DeleteUser removes an account. Nobody can delete their own account, so there is always an admin left to sign in.
+func DeleteUser(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	user, err := scanUser(config.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if identity, _ := middleware.CurrentUser(c); identity.UserID == user.ID {
+		return c.Status(400).JSON(fiber.Map{"error": "You cannot delete your own account"})
+	}
+
+	if err := signOutUser(user); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := config.DB.Exec("DELETE FROM sessions WHERE user_id = ?", id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := config.DB.Exec("DELETE FROM api_keys WHERE user_id = ?", id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := config.DB.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	config.LogMessage("INFO", fmt.Sprintf("User %s deleted by %s", user.Username, middleware.CurrentUsername(c)))
+	return c.JSON(fiber.Map{"message": "User deleted successfully"})
+}
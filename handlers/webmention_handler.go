@@ -0,0 +1,549 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Limits on the webmentions a sender can submit
+const (
+	maxWebmentionURLLength = 2048
+	// Each IP address can send webmentionRateLimit webmentions per webmentionRateWindow, an SQLite datetime modifier
+	webmentionRateLimit  = 20
+	webmentionRateWindow = "-10 minutes"
+)
+
+// Limits on fetching a webmention's source to verify it
+const (
+	webmentionFetchTimeout  = 15 * time.Second
+	maxWebmentionSourceSize = 1 << 20
+	maxWebmentionRedirects  = 5
+	maxWebmentionAttempts   = 3
+	// Sources that could not be fetched are tried again once webmentionRetryDelay, an SQLite datetime modifier, has passed
+	webmentionRetryDelay    = "-5 minutes"
+	webmentionBatchSize     = 20
+	maxWebmentionContentLen = 500
+	maxWebmentionAuthorLen  = 100
+)
+
+const webmentionColumns = "w.id, w.blog_id, b.title, w.source, w.target, w.type, w.author_name, w.author_url, w.author_photo, w.content, w.url, w.published_at, w.status, w.error, w.created_at, w.updated_at"
+
+var (
+	errWebmentionTarget   = errors.New("target is not a post on this site")
+	errPrivateAddress     = errors.New("source resolves to a private address")
+	errTooManyRedirects   = errors.New("source redirected too many times")
+	errWebmentionNotFound = errors.New("source no longer exists")
+)
+
+// sourceStatusError is an unsuccessful HTTP status returned when fetching a webmention's source
+type sourceStatusError int
+
+func (e sourceStatusError) Error() string {
+	return fmt.Sprintf("source returned HTTP %d", int(e))
+}
+
+// webmentionQueued wakes the verifier when a webmention arrives, rather than leaving it for the next tick
+var webmentionQueued = make(chan struct{}, 1)
+
+// webmentionVerifier checks webmentions against their sources, fetching them with its client
+type webmentionVerifier struct {
+	client *http.Client
+}
+
+// defaultWebmentionVerifier is the verifier the background job uses. It refuses to connect to loopback and
+// private addresses so a sender cannot use it to reach services on the server's own network.
+var defaultWebmentionVerifier = webmentionVerifier{client: newWebmentionClient(dialPublicOnly)}
+
+// newWebmentionClient makes a client for fetching webmention sources, checking each address it connects
+// to with control, which may be nil to allow any
+func newWebmentionClient(control func(network string, address string, c syscall.RawConn) error) *http.Client {
+	return &http.Client{
+		Timeout: webmentionFetchTimeout,
+		Transport: &http.Transport{
+			DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: control}).DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxWebmentionRedirects {
+				return errTooManyRedirects
+			}
+			return nil
+		},
+	}
+}
+
+// carrierGradeNAT is the shared address space of RFC 6598, which net.IP does not count as private but
+// overlay networks such as Tailscale use for hosts on the server's own network
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// dialPublicOnly stops connections to addresses that are not on the public internet. It runs once the
+// host name has been resolved, so a name pointing at a private address is caught too.
+func dialPublicOnly(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || carrierGradeNAT.Contains(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// WebmentionQueued is signalled whenever a webmention is waiting to be verified
+func WebmentionQueued() <-chan struct{} {
+	return webmentionQueued
+}
+
+// queueWebmentionVerification wakes the verifier without blocking if it is already due to run
+func queueWebmentionVerification() {
+	select {
+	case webmentionQueued <- struct{}{}:
+	default:
+	}
+}
+
+func scanWebmention(row rowScanner) (models.Webmention, error) {
+	var mention models.Webmention
+	var blogTitle sql.NullString
+	var publishedAt sql.NullTime
+	err := row.Scan(&mention.ID, &mention.BlogID, &blogTitle, &mention.Source, &mention.Target, &mention.Type,
+		&mention.AuthorName, &mention.AuthorURL, &mention.AuthorPhoto, &mention.Content, &mention.URL, &publishedAt,
+		&mention.Status, &mention.Error, &mention.CreatedAt, &mention.UpdatedAt)
+	mention.BlogTitle = blogTitle.String
+	if publishedAt.Valid {
+		mention.PublishedAt = &publishedAt.Time
+	}
+	return mention, err
+}
+
+// queryWebmentions selects webmentions, joined to their post, using the given WHERE/ORDER BY clause
+func queryWebmentions(clause string, args ...any) ([]models.Webmention, error) {
+	rows, err := config.DB.Query("SELECT "+webmentionColumns+" FROM webmentions w LEFT JOIN blogs b ON b.id = w.blog_id "+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := []models.Webmention{}
+	for rows.Next() {
+		mention, err := scanWebmention(rows)
+		if err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, mention)
+	}
+
+	return mentions, rows.Err()
+}
+
+// parseHTTPURL parses an absolute http or https URL
+func parseHTTPURL(raw string) (*url.URL, bool) {
+	if raw == "" || len(raw) > maxWebmentionURLLength {
+		return nil, false
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, false
+	}
+	return parsed, true
+}
+
+// sameURL reports whether two URLs point at the same page, ignoring fragments and a trailing slash
+func sameURL(a string, b string) bool {
+	normalize := func(raw string) string {
+		parsed, err := url.Parse(raw)
+		if err != nil {
+			return raw
+		}
+		parsed.Fragment = ""
+		parsed.Scheme = strings.ToLower(parsed.Scheme)
+		parsed.Host = strings.ToLower(parsed.Host)
+		parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+		return parsed.String()
+	}
+	return normalize(a) == normalize(b)
+}
+
+// webmentionTargetBlog finds the published post a webmention target is the permalink of, including slugs the
+// post used to have
+func webmentionTargetBlog(target *url.URL) (int, error) {
+	site, err := url.Parse(siteURL())
+	if err != nil || !strings.EqualFold(target.Hostname(), site.Hostname()) {
+		return 0, errWebmentionTarget
+	}
+
+	slug, ok := strings.CutPrefix(strings.TrimSuffix(target.Path, "/"), "/blog/")
+	if !ok || !IsValidSlug(slug) {
+		return 0, errWebmentionTarget
+	}
+
+	var id int
+	err = config.DB.QueryRow(
+		`SELECT id FROM blogs WHERE status = ? AND (slug = ? OR id IN (SELECT blog_id FROM blog_slug_redirects WHERE old_slug = ?))`,
+		models.BlogStatusPublished, slug, slug,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, errWebmentionTarget
+	}
+	return id, err
+}
+
+// ReceiveWebmention accepts a W3C Webmention: a form with the URL of a page (source) that links to one of
+// the site's posts (target). The request is queued and answered with 202 Accepted, and the source is fetched
+// in the background to check the link and pull out who wrote it and what they said.
+func ReceiveWebmention(c *fiber.Ctx) error {
+	source := strings.TrimSpace(c.FormValue("source"))
+	target := strings.TrimSpace(c.FormValue("target"))
+
+	sourceURL, ok := parseHTTPURL(source)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "source must be an http or https URL"})
+	}
+	targetURL, ok := parseHTTPURL(target)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "target must be an http or https URL"})
+	}
+	if sameURL(sourceURL.String(), targetURL.String()) {
+		return c.Status(400).JSON(fiber.Map{"error": "source and target must be different"})
+	}
+
+	blogID, err := webmentionTargetBlog(targetURL)
+	if errors.Is(err, errWebmentionTarget) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	ip := c.IP()
+	var recent int
+	err = config.DB.QueryRow(
+		"SELECT COUNT(*) FROM webmentions WHERE ip_address = ? AND updated_at >= datetime('now', ?)", ip, webmentionRateWindow,
+	).Scan(&recent)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if recent >= webmentionRateLimit {
+		return c.Status(429).JSON(fiber.Map{"error": "Too many webmentions, please try again later"})
+	}
+
+	// Sending the same webmention again asks for it to be checked again, which is how senders report
+	// that the source was updated or deleted
+	_, err = config.DB.Exec(`
+		INSERT INTO webmentions (blog_id, source, target, status, ip_address) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(source, target) DO UPDATE SET blog_id = excluded.blog_id, status = excluded.status, attempts = 0,
+			error = '', ip_address = excluded.ip_address, updated_at = CURRENT_TIMESTAMP`,
+		blogID, source, target, models.WebmentionStatusPending, ip,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	queueWebmentionVerification()
+	return c.Status(202).JSON(fiber.Map{"message": "Webmention accepted and queued for verification"})
+}
+
+// fetchSource downloads a webmention's source page, returning its body, media type and final URL
+func (v webmentionVerifier) fetchSource(source string) ([]byte, string, *url.URL, error) {
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	req.Header.Set("Accept", "text/html, text/plain;q=0.9, */*;q=0.1")
+	req.Header.Set("User-Agent", "Webmention verifier (+"+siteURL()+")")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil, errWebmentionNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", nil, sourceStatusError(resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxWebmentionSourceSize))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return body, mediaType, resp.Request.URL, nil
+}
+
+// htmlLinksTo reports whether an HTML page links to or embeds the target
+func htmlLinksTo(doc *xhtml.Node, base *url.URL, target string) bool {
+	var walk func(*xhtml.Node) bool
+	walk = func(n *xhtml.Node) bool {
+		if n.Type == xhtml.ElementNode {
+			if link := mf2LinkURL(n, base); link != "" && n.DataAtom != atom.Link && sameURL(link, target) {
+				return true
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if walk(child) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(doc)
+}
+
+// parseMF2Time parses the date formats microformats2 allows
+func parseMF2Time(value string) *time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05-0700", "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			t = normalizePublishTime(t)
+			return &t
+		}
+	}
+	return nil
+}
+
+// httpURLOrEmpty drops anything but http and https links so a source cannot slip other schemes onto the page
+func httpURLOrEmpty(raw string) string {
+	if _, ok := parseHTTPURL(raw); ok {
+		return raw
+	}
+	return ""
+}
+
+// applyHEntry fills in a webmention from the source's h-entry: its kind, author, content, permalink and date
+func applyHEntry(mention *models.Webmention, entry *mf2Item) {
+	linksTarget := func(link string) bool { return sameURL(link, mention.Target) }
+	switch {
+	case entry.hasValue("in-reply-to", linksTarget):
+		mention.Type = models.WebmentionTypeReply
+	case entry.hasValue("like-of", linksTarget):
+		mention.Type = models.WebmentionTypeLike
+	case entry.hasValue("repost-of", linksTarget):
+		mention.Type = models.WebmentionTypeRepost
+	}
+
+	if authors := entry.properties["author"]; len(authors) > 0 {
+		author := authors[0]
+		mention.AuthorName = author.text
+		if author.item != nil {
+			mention.AuthorName = author.item.first("name")
+			mention.AuthorURL = httpURLOrEmpty(author.item.first("url"))
+			mention.AuthorPhoto = httpURLOrEmpty(author.item.first("photo"))
+		} else if _, ok := parseHTTPURL(author.text); ok {
+			mention.AuthorURL = author.text
+		}
+	}
+
+	for _, property := range []string{"content", "summary", "name"} {
+		if text := entry.first(property); text != "" {
+			mention.Content = truncateWords(text, maxWebmentionContentLen)
+			break
+		}
+	}
+
+	if link := httpURLOrEmpty(entry.first("url")); link != "" {
+		mention.URL = link
+	}
+	mention.PublishedAt = parseMF2Time(entry.first("published"))
+}
+
+// verify fetches a webmention's source, checks it still links to the post and reads its details
+func (v webmentionVerifier) verify(mention *models.Webmention) error {
+	body, mediaType, base, err := v.fetchSource(mention.Source)
+	if err != nil {
+		return err
+	}
+
+	mention.Type = models.WebmentionTypeMention
+	mention.AuthorName, mention.AuthorURL, mention.AuthorPhoto = "", "", ""
+	mention.Content, mention.URL, mention.PublishedAt = "", mention.Source, nil
+
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		if !bytes.Contains(body, []byte(mention.Target)) {
+			return errors.New("source does not link to target")
+		}
+	} else {
+		doc, err := xhtml.Parse(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("source is not valid HTML: %w", err)
+		}
+		if !htmlLinksTo(doc, base, mention.Target) {
+			return errors.New("source does not link to target")
+		}
+		if entry := findMF2Item(doc, "h-entry", base); entry != nil {
+			applyHEntry(mention, entry)
+		}
+	}
+
+	if mention.AuthorName == "" {
+		mention.AuthorName = base.Hostname()
+	}
+	mention.AuthorName = truncateWords(mention.AuthorName, maxWebmentionAuthorLen)
+	return nil
+}
+
+// VerifyPendingWebmentions checks a batch of queued webmentions against their sources. Sources that cannot be
+// reached are retried on later runs, up to maxWebmentionAttempts times.
+func VerifyPendingWebmentions() {
+	defaultWebmentionVerifier.verifyPending()
+}
+
+// verifyPending does the work of VerifyPendingWebmentions with this verifier
+func (v webmentionVerifier) verifyPending() {
+	mentions, err := queryWebmentions(
+		"WHERE w.status = ? AND (w.attempts = 0 OR w.updated_at <= datetime('now', ?)) ORDER BY w.updated_at LIMIT ?",
+		models.WebmentionStatusPending, webmentionRetryDelay, webmentionBatchSize,
+	)
+	if err != nil {
+		config.LogMessage("ERROR", "Error loading pending webmentions: "+err.Error())
+		return
+	}
+
+	for _, mention := range mentions {
+		err := v.verify(&mention)
+		switch {
+		case err == nil:
+			_, err = config.DB.Exec(
+				"UPDATE webmentions SET status = ?, type = ?, author_name = ?, author_url = ?, author_photo = ?, content = ?, url = ?, published_at = ?, error = '', updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+				models.WebmentionStatusVerified, mention.Type, mention.AuthorName, mention.AuthorURL, mention.AuthorPhoto,
+				mention.Content, mention.URL, mention.PublishedAt, mention.ID,
+			)
+			if err == nil {
+				config.LogMessage("INFO", fmt.Sprintf("Verified webmention %d from %s to blog %d", mention.ID, mention.Source, mention.BlogID))
+			}
+		case errors.Is(err, errWebmentionNotFound):
+			// The source was deleted, so the mention goes too
+			_, err = config.DB.Exec("DELETE FROM webmentions WHERE id = ?", mention.ID)
+		case isSourceFetchError(err):
+			_, err = config.DB.Exec(
+				"UPDATE webmentions SET attempts = attempts + 1, status = CASE WHEN attempts + 1 >= ? THEN ? ELSE status END, error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+				maxWebmentionAttempts, models.WebmentionStatusRejected, err.Error(), mention.ID,
+			)
+		default:
+			_, err = config.DB.Exec(
+				"UPDATE webmentions SET status = ?, error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+				models.WebmentionStatusRejected, err.Error(), mention.ID,
+			)
+		}
+		if err != nil {
+			config.LogMessage("ERROR", fmt.Sprintf("Error saving webmention %d: %v", mention.ID, err))
+		}
+	}
+
+	// Carry straight on if there may be more waiting
+	if len(mentions) == webmentionBatchSize {
+		queueWebmentionVerification()
+	}
+}
+
+// isSourceFetchError reports whether a source could not be fetched for reasons that may pass, such as a
+// timeout or a server error, as opposed to the source being fetched and found wanting
+func isSourceFetchError(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return !errors.Is(err, errPrivateAddress) && !errors.Is(err, errTooManyRedirects)
+	}
+	var status sourceStatusError
+	if errors.As(err, &status) {
+		return status >= 500 || status == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// getVerifiedWebmentions lists the verified webmentions of a post, oldest first
+func getVerifiedWebmentions(blogID int) ([]models.Webmention, error) {
+	mentions, err := queryWebmentions(
+		"WHERE w.blog_id = ? AND w.status = ? ORDER BY COALESCE(w.published_at, w.created_at), w.id",
+		blogID, models.WebmentionStatusVerified,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Readers only need what is shown on the post
+	for i := range mentions {
+		mentions[i].Status = ""
+		mentions[i].BlogTitle = ""
+	}
+	return mentions, nil
+}
+
+// GetBlogWebmentions lists the verified webmentions of a published post
+func GetBlogWebmentions(c *fiber.Ctx) error {
+	blogID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid blog ID"})
+	}
+
+	var exists int
+	err = config.DB.QueryRow("SELECT 1 FROM blogs WHERE id = ? AND "+blogVisibilityFilter(c), blogID).Scan(&exists)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Blog not found"})
+	}
+
+	mentions, err := getVerifiedWebmentions(blogID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(mentions)
+}
+
+// GetWebmentions lists received webmentions, newest first, optionally only those with the given ?status=
+func GetWebmentions(c *fiber.Ctx) error {
+	where := ""
+	var args []any
+	if status := c.Query("status"); status != "" {
+		switch status {
+		case models.WebmentionStatusPending, models.WebmentionStatusVerified, models.WebmentionStatusRejected:
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid status"})
+		}
+		where = "WHERE w.status = ? "
+		args = append(args, status)
+	}
+
+	mentions, err := queryWebmentions(where+"ORDER BY w.updated_at DESC LIMIT ?", append(args, maxModerationQueue)...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(mentions)
+}
+
+// DeleteWebmention removes a webmention, taking it off its post
+func DeleteWebmention(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid webmention ID"})
+	}
+
+	result, err := config.DB.Exec("DELETE FROM webmentions WHERE id = ?", id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Webmention not found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Webmention deleted successfully"})
+}
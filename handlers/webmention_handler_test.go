@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"database/sql"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testWebmentionTarget = "https://example.com/blog/hello-world"
+
+// queueTestWebmention adds a pending webmention from source to the test target, returning its ID
+func queueTestWebmention(t *testing.T, source string) int {
+	t.Helper()
+	result, err := config.DB.Exec(
+		"INSERT INTO webmentions (blog_id, source, target, status) VALUES (1, ?, ?, ?)",
+		source, testWebmentionTarget, models.WebmentionStatusPending,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// loadTestWebmention reads back a webmention, reporting false if it has been deleted
+func loadTestWebmention(t *testing.T, id int) (models.Webmention, bool) {
+	t.Helper()
+	mention, err := scanWebmention(config.DB.QueryRow(
+		"SELECT "+webmentionColumns+" FROM webmentions w LEFT JOIN blogs b ON b.id = w.blog_id WHERE w.id = ?", id,
+	))
+	if err == sql.ErrNoRows {
+		return models.Webmention{}, false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mention, true
+}
+
+// serveSource starts a source server answering every request with the given status and HTML
+func serveSource(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVerifyPendingWebmentions(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus string
+		wantType   string
+		wantAuthor string
+		wantGone   bool
+	}{
+		{
+			name:   "linking reply is verified",
+			status: http.StatusOK,
+			body: `<div class="h-entry">
+				<a class="p-author h-card" href="https://alice.example">Alice</a>
+				<p class="e-content">Great post about <a class="u-in-reply-to" href="` + testWebmentionTarget + `">this</a></p>
+			</div>`,
+			wantStatus: models.WebmentionStatusVerified,
+			wantType:   models.WebmentionTypeReply,
+			wantAuthor: "Alice",
+		},
+		{
+			name:       "source without a link is rejected",
+			status:     http.StatusOK,
+			body:       `<p>Nothing to see here</p>`,
+			wantStatus: models.WebmentionStatusRejected,
+		},
+		{
+			name:     "gone source removes the mention",
+			status:   http.StatusGone,
+			wantGone: true,
+		},
+		{
+			name:     "deleted source removes the mention",
+			status:   http.StatusNotFound,
+			wantGone: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDatabase(t)
+			server := serveSource(t, tt.status, tt.body)
+			id := queueTestWebmention(t, server.URL+"/post")
+
+			webmentionVerifier{client: server.Client()}.verifyPending()
+
+			mention, ok := loadTestWebmention(t, id)
+			if tt.wantGone {
+				if ok {
+					t.Fatalf("mention still exists with status %q", mention.Status)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("mention was deleted")
+			}
+			if mention.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q (error %q)", mention.Status, tt.wantStatus, mention.Error)
+			}
+			if tt.wantType != "" && mention.Type != tt.wantType {
+				t.Errorf("type = %q, want %q", mention.Type, tt.wantType)
+			}
+			if tt.wantAuthor != "" && mention.AuthorName != tt.wantAuthor {
+				t.Errorf("author = %q, want %q", mention.AuthorName, tt.wantAuthor)
+			}
+		})
+	}
+}
+
+func TestVerifyPendingWebmentionsRefusesPrivateSource(t *testing.T) {
+	setupTestDatabase(t)
+	server := serveSource(t, http.StatusOK, `<a href="`+testWebmentionTarget+`">link</a>`)
+	id := queueTestWebmention(t, server.URL+"/post")
+
+	webmentionVerifier{client: newWebmentionClient(dialPublicOnly)}.verifyPending()
+
+	mention, ok := loadTestWebmention(t, id)
+	if !ok {
+		t.Fatal("mention was deleted")
+	}
+	if mention.Status != models.WebmentionStatusRejected || !strings.Contains(mention.Error, errPrivateAddress.Error()) {
+		t.Errorf("status = %q, error = %q, want rejected as a private address", mention.Status, mention.Error)
+	}
+}
+
+func TestDialPublicOnly(t *testing.T) {
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.0.0.5", false},
+		{"192.168.1.10", false},
+		{"172.16.0.1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"100.128.0.1", true},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+	}
+
+	for _, tt := range tests {
+		err := dialPublicOnly("tcp", net.JoinHostPort(tt.ip, "443"), nil)
+		if allowed := err == nil; allowed != tt.allowed {
+			t.Errorf("dialPublicOnly(%s) allowed = %v, want %v", tt.ip, allowed, tt.allowed)
+		}
+	}
+}
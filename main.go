@@ -4,9 +4,12 @@ import (
 	"PersonalWebsiteGO/config"
 	"PersonalWebsiteGO/handlers"
 	"PersonalWebsiteGO/middleware"
+	"PersonalWebsiteGO/models"
 	"PersonalWebsiteGO/background"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -29,6 +32,31 @@ func renderWithTime(c *fiber.Ctx, view string, data fiber.Map, layout string) er
 	return nil
 }
 
+// proxyConfig sets where client addresses come from when the site runs behind a reverse proxy.
+// TRUSTED_PROXIES is a comma separated list of the proxies' addresses or CIDR ranges, and PROXY_HEADER names
+// the header they put the client's address in (X-Real-IP by default; use CF-Connecting-IP behind Cloudflare).
+// The proxy must overwrite that header rather than append to it. Requests from anywhere else, and every
+// request when TRUSTED_PROXIES is unset, use the connection's address so the header can't be spoofed.
+func proxyConfig(cfg *fiber.Config) {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	if len(proxies) == 0 {
+		return
+	}
+
+	cfg.EnableTrustedProxyCheck = true
+	cfg.TrustedProxies = proxies
+	cfg.EnableIPValidation = true
+	cfg.ProxyHeader = os.Getenv("PROXY_HEADER")
+	if cfg.ProxyHeader == "" {
+		cfg.ProxyHeader = "X-Real-IP"
+	}
+}
+
 func main() {
 	if err := godotenv.Load(); err != nil {
 		log.Println("Warning: .env file not found, using system environment variables")
@@ -39,15 +67,46 @@ func main() {
 	}
 	defer config.CloseDatabase()
 
+	if err := handlers.RenderPendingBlogs(); err != nil {
+		log.Println("Failed to render pending blogs:", err)
+	}
+
+	if err := handlers.BackfillBlogSlugs(); err != nil {
+		log.Println("Failed to generate blog slugs:", err)
+	}
+
+	if err := handlers.RebuildSearchIndex(); err != nil {
+		log.Println("Failed to rebuild blog search index:", err)
+	}
+
+	if err := handlers.BootstrapAdminUser(); err != nil {
+		log.Println("Failed to create the first user account:", err)
+	}
+
 	engine := html.New("./views", ".html")
+	engine.AddFuncMap(handlers.TemplateFuncs())
 
-	app := fiber.New(fiber.Config{
+	appConfig := fiber.Config{
 		Views: engine,
-	})
+		// Bodies are read as handlers need them, so large uploads are spooled to temporary files and
+		// middleware.BodyLimit below is what limits their size
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	}
+	// Logins, comments and webmentions are rate limited by client address, so it has to be the real one
+	proxyConfig(&appConfig)
+	app := fiber.New(appConfig)
 
 	engine.Reload(true)
 
+	// Only uploads to the media library and imports need more than the default
+	app.Use(middleware.BodyLimit(fiber.DefaultBodyLimit, map[string]int{
+		"/api/media":        handlers.MaxMediaRequestSize,
+		"/api/blogs/import": handlers.MaxImportRequestSize,
+	}))
+
 	app.Static("/static", "./static")
+	app.Static("/media", handlers.MediaDir(), fiber.Static{MaxAge: 31536000})
 
 	app.Get("/", func(c *fiber.Ctx) error {
 		return renderWithTime(c, "index", fiber.Map{"Title": "Home"}, "layout/base")
@@ -73,32 +132,109 @@ func main() {
 		return renderWithTime(c, "projects/software", fiber.Map{"Title": "Software Development"}, "layout/base")
 	})
 
-	app.Get("/logs", middleware.AuthMiddleware, handlers.RenderLogsPage)
+	// Signing in is enough to read drafts; changing content and the moderation queues, which hold commenters'
+	// email and IP addresses, need an editor, and accounts, logs and the servers behind the site need an admin
+	requireEditor := middleware.RequireRole(models.RoleEditor)
+	requireAdmin := middleware.RequireRole(models.RoleAdmin)
+	// Routes scripts can also use with an API key that has the scope
+	blogsRead := middleware.ScopedAuthMiddleware(models.ScopeBlogsRead)
+	blogsWrite := middleware.ScopedAuthMiddleware(models.ScopeBlogsWrite)
+	commentsModerate := middleware.ScopedAuthMiddleware(models.ScopeCommentsModerate)
+	minecraftCommand := middleware.ScopedAuthMiddleware(models.ScopeMinecraftCommand)
+	proxmoxRead := middleware.ScopedAuthMiddleware(models.ScopeProxmoxRead)
+	ipRead := middleware.ScopedAuthMiddleware(models.ScopeIPRead)
+
+	app.Get("/logs", middleware.AuthMiddleware, requireAdmin, handlers.RenderLogsPage)
 
 	app.Get("/projects/blogs", handlers.RenderBlogsPage)
+	app.Get("/blog/:slug", handlers.RenderBlogPostPage)
+	app.Get("/blog/:slug/preview.png", handlers.BlogPreviewImage)
+	app.Get("/blog/tag/:tag", handlers.RenderTagPage)
+
+	app.Get("/feed.xml", handlers.RSSFeed)
+	app.Get("/atom.xml", handlers.AtomFeed)
+	app.Get("/feed.json", handlers.JSONFeed)
+
+	app.Get("/sitemap.xml", handlers.Sitemap)
+	app.Get("/sitemap-:page.xml", handlers.SitemapPage)
+	app.Get("/robots.txt", handlers.RobotsTxt)
 
 	app.Post("/api/auth/login", handlers.Login)
-	app.Get("/api/auth/check", middleware.AuthMiddleware, handlers.CheckAuth)
+	app.Get("/api/auth/check", middleware.PasswordChangeAuthMiddleware, handlers.CheckAuth)
+	app.Post("/api/auth/login/2fa", handlers.LoginTwoFactor)
+	app.Post("/api/auth/refresh", handlers.RefreshToken)
+	app.Post("/api/auth/logout", middleware.PasswordChangeAuthMiddleware, handlers.Logout)
+	app.Put("/api/auth/password", middleware.PasswordChangeAuthMiddleware, handlers.ChangePassword)
+	app.Get("/api/auth/sessions", middleware.AuthMiddleware, handlers.GetSessions)
+	app.Delete("/api/auth/sessions", middleware.AuthMiddleware, handlers.RevokeAllSessions)
+	app.Delete("/api/auth/sessions/:id", middleware.AuthMiddleware, handlers.RevokeSession)
+	app.Get("/api/auth/2fa", middleware.AuthMiddleware, handlers.GetTwoFactorStatus)
+	app.Post("/api/auth/2fa/setup", middleware.AuthMiddleware, handlers.SetupTwoFactor)
+	app.Post("/api/auth/2fa/enable", middleware.AuthMiddleware, handlers.EnableTwoFactor)
+	app.Post("/api/auth/2fa/disable", middleware.AuthMiddleware, handlers.DisableTwoFactor)
+	app.Post("/api/auth/2fa/recovery-codes", middleware.AuthMiddleware, handlers.RegenerateRecoveryCodes)
+	app.Get("/api/auth/api-keys", middleware.AuthMiddleware, handlers.GetAPIKeys)
+	app.Post("/api/auth/api-keys", middleware.AuthMiddleware, handlers.CreateAPIKey)
+	app.Delete("/api/auth/api-keys/:id", middleware.AuthMiddleware, handlers.DeleteAPIKey)
+
+	app.Get("/api/users", middleware.AuthMiddleware, requireAdmin, handlers.GetUsers)
+	app.Post("/api/users", middleware.AuthMiddleware, requireAdmin, handlers.CreateUser)
+	app.Post("/api/users/:id/reset-password", middleware.AuthMiddleware, requireAdmin, handlers.ResetUserPassword)
+	app.Put("/api/users/:id/role", middleware.AuthMiddleware, requireAdmin, handlers.UpdateUserRole)
+	app.Delete("/api/users/:id", middleware.AuthMiddleware, requireAdmin, handlers.DeleteUser)
+	app.Get("/api/users/:id/sessions", middleware.AuthMiddleware, requireAdmin, handlers.GetUserSessions)
+	app.Delete("/api/users/:id/sessions", middleware.AuthMiddleware, requireAdmin, handlers.RevokeUserSessions)
+	app.Delete("/api/users/:id/2fa", middleware.AuthMiddleware, requireAdmin, handlers.ResetUserTwoFactor)
 
 	app.Get("/api/blogs", handlers.GetAllBlogs)
+	app.Get("/api/blogs/search", handlers.SearchBlogs)
+	app.Get("/api/blogs/export", blogsRead, handlers.ExportBlogs)
+	app.Get("/api/tags", handlers.GetTags)
 	app.Get("/api/blogs/:id", handlers.GetBlogByID)
 
-	app.Post("/api/blogs", middleware.AuthMiddleware, handlers.CreateBlog)
-	app.Put("/api/blogs/:id", middleware.AuthMiddleware, handlers.UpdateBlog)
-	app.Delete("/api/blogs/:id", middleware.AuthMiddleware, handlers.DeleteBlog)
+	app.Post("/api/blogs", blogsWrite, requireEditor, handlers.CreateBlog)
+	app.Post("/api/blogs/preview", blogsWrite, requireEditor, handlers.PreviewBlog)
+	app.Post("/api/blogs/import", blogsWrite, requireEditor, handlers.ImportBlogs)
+	app.Put("/api/blogs/:id", blogsWrite, requireEditor, handlers.UpdateBlog)
+	app.Patch("/api/blogs/:id", blogsWrite, requireEditor, handlers.PatchBlog)
+	app.Delete("/api/blogs/:id", blogsWrite, requireEditor, handlers.DeleteBlog)
+
+	app.Get("/api/blogs/:id/comments", handlers.GetBlogComments)
+	app.Post("/api/blogs/:id/comments", handlers.CreateComment)
+
+	app.Get("/api/comments", commentsModerate, requireEditor, handlers.GetComments)
+	app.Put("/api/comments/:id", commentsModerate, requireEditor, handlers.ModerateComment)
+	app.Delete("/api/comments/:id", commentsModerate, requireEditor, handlers.DeleteComment)
+
+	app.Post("/webmention", handlers.ReceiveWebmention)
+	app.Get("/api/blogs/:id/webmentions", handlers.GetBlogWebmentions)
+	app.Get("/api/webmentions", commentsModerate, requireEditor, handlers.GetWebmentions)
+	app.Delete("/api/webmentions/:id", commentsModerate, requireEditor, handlers.DeleteWebmention)
+
+	app.Get("/api/blogs/:id/revisions", blogsRead, handlers.GetBlogRevisions)
+	app.Get("/api/blogs/:id/revisions/diff", blogsRead, handlers.DiffBlogRevisions)
+	app.Post("/api/blogs/:id/revisions/:revisionId/restore", blogsWrite, requireEditor, handlers.RestoreBlogRevision)
+
+	app.Get("/api/media", blogsRead, handlers.GetAllMedia)
+	app.Post("/api/media", blogsWrite, requireEditor, handlers.UploadMedia)
+	app.Delete("/api/media/:id", blogsWrite, requireEditor, handlers.DeleteMedia)
 
 	app.Get("/api/minecraft/status", handlers.Status)
 	app.Get("/api/minecraft/playerlist", handlers.PlayerList)
-	app.Get("/api/minecraft/sendmessage", handlers.SendMessage)
+	app.Get("/api/minecraft/sendmessage", minecraftCommand, requireAdmin, handlers.SendMessage)
 	app.Get("/api/minecraft/playtime", handlers.GetPlaytime)
 
 	app.Get("/other/servicestatus", handlers.RenderServerStatusPage)
 
-	app.Get("/api/proxmox/vmstatus", handlers.AllVMStatus)
-	app.Get("/api/proxmox/getvmstatus", handlers.GetVMStatus)
-	app.Get("/api/proxmox/getvmdetailedstatus", handlers.GetVMDetailedStatus)
+	app.Get("/api/proxmox/vmstatus", proxmoxRead, requireAdmin, handlers.AllVMStatus)
+	app.Get("/api/proxmox/getvmstatus", proxmoxRead, requireAdmin, handlers.GetVMStatus)
+	app.Get("/api/proxmox/getvmdetailedstatus", proxmoxRead, requireAdmin, handlers.GetVMDetailedStatus)
 
-	app.Get("/api/ip/currentpublicip", handlers.GetCurrentPublicIp)
+	app.Get("/api/ip/currentpublicip", ipRead, requireAdmin, handlers.GetCurrentPublicIp)
+
+	// Every page route is registered by now, so the sitemap can list them. Pages whose template has not been
+	// written yet are left out until it is.
+	handlers.SetSitemapRoutes(app.GetRoutes(true), "/projects/portfolio", "/projects/software")
 
 	fmt.Println("Server starting on http://localhost:3000")
 
@@ -110,5 +246,13 @@ func main() {
 
 	fmt.Println("Background public IP validator started.")
 
+	background.StartScheduledBlogPublisher()
+
+	fmt.Println("Background scheduled blog publisher started.")
+
+	background.StartWebmentionVerifier()
+
+	fmt.Println("Background webmention verifier started.")
+
 	app.Listen("0.0.0.0:3000")
 }
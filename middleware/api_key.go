@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIKeyHeader is where scripts send their API key
+const APIKeyHeader = "X-API-Key"
+
+const (
+	// apiKeyMarker starts every API key, so leaked keys are easy to recognise and search for
+	apiKeyMarker      = "pwg_"
+	apiKeyIDBytes     = 4
+	apiKeySecretBytes = 32
+	// apiKeyLastUsedInterval, an SQLite datetime modifier, bounds how often a key's last use is written down
+	apiKeyLastUsedInterval = "-1 minutes"
+)
+
+var errInvalidAPIKey = errors.New("invalid or expired API key")
+
+// GenerateAPIKey makes a new API key like pwg_1a2b3c4d_<secret>, returning it along with the prefix it is
+// shown and looked up by and the hash it is stored as. The key itself is not kept, so it can only be shown once.
+func GenerateAPIKey() (string, string, string, error) {
+	id := make([]byte, apiKeyIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", err
+	}
+	secret := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	prefix := apiKeyMarker + hex.EncodeToString(id)
+	key := prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, hashAPIKey(key), nil
+}
+
+// hashAPIKey is what an API key is stored as. Keys are random enough that a fast hash is as good as a slow one.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyPrefix is the visible part of an API key, reporting false for strings that are not shaped like one
+func apiKeyPrefix(key string) (string, bool) {
+	length := len(apiKeyMarker) + hex.EncodedLen(apiKeyIDBytes)
+	if !strings.HasPrefix(key, apiKeyMarker) || len(key) <= length+1 || key[length] != '_' {
+		return "", false
+	}
+	return key[:length], true
+}
+
+// identityFromAPIKey looks up the user an API key belongs to, with the role they have now, and notes when and
+// where the key was used
+func identityFromAPIKey(c *fiber.Ctx, key string) (Identity, error) {
+	prefix, ok := apiKeyPrefix(key)
+	if !ok {
+		return Identity{}, errInvalidAPIKey
+	}
+
+	var (
+		identity Identity
+		keyHash  string
+		scopes   string
+		expired  bool
+	)
+	err := config.DB.QueryRow(`
+		SELECT k.id, k.key_hash, k.scopes, k.expires_at IS NOT NULL AND k.expires_at <= CURRENT_TIMESTAMP,
+			u.id, u.username, u.role, u.must_change_password
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.prefix = ?`, prefix,
+	).Scan(&identity.APIKeyID, &keyHash, &scopes, &expired, &identity.UserID, &identity.Username, &identity.Role,
+		&identity.MustChangePassword)
+	if err == sql.ErrNoRows {
+		return Identity{}, errInvalidAPIKey
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(keyHash), []byte(hashAPIKey(key))) != 1 || expired || !models.IsValidRole(identity.Role) {
+		return Identity{}, errInvalidAPIKey
+	}
+	identity.Scopes = strings.Fields(scopes)
+
+	_, err = config.DB.Exec(`UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < datetime('now', ?) OR last_used_ip != ?)`,
+		c.IP(), identity.APIKeyID, apiKeyLastUsedInterval, c.IP())
+	return identity, err
+}
+
+// authenticateAPIKey is AuthMiddleware for requests made with an API key, which are only let through
+// to routes accepting keys with a scope the key has
+func authenticateAPIKey(c *fiber.Ctx, key string, scope string) error {
+	if scope == "" {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "API keys cannot be used for this",
+		})
+	}
+
+	identity, err := identityFromAPIKey(c, key)
+	if err == errInvalidAPIKey {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Invalid or expired API key",
+		})
+	}
+	if err != nil {
+		config.LogMessage("ERROR", fmt.Sprintf("Failed to check API key: %v", err))
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to check API key",
+		})
+	}
+	if !identity.HasScope(scope) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": fmt.Sprintf("API key does not have the %s scope", scope),
+		})
+	}
+	if identity.MustChangePassword {
+		return passwordChangeRequired(c)
+	}
+
+	c.Locals("user", identity)
+	c.Locals("username", identity.Username)
+	return c.Next()
+}
@@ -1,7 +1,11 @@
 package middleware
 
 import (
+	"PersonalWebsiteGO/models"
+	"errors"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -9,25 +13,35 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// AuthMiddleware checks if the user is authenticated
-func AuthMiddleware(c *fiber.Ctx) error {
+var errNoToken = errors.New("no authorization header or cookie")
+
+const (
+	twoFactorChallengePurpose = "two_factor"
+	// twoFactorChallengeLifetime is how long someone has to enter their second factor after their password
+	twoFactorChallengeLifetime = 5 * time.Minute
+)
+
+// parseRequestToken reads the JWT from the Authorization header or authToken cookie and validates it,
+// reporting whether it came from the cookie
+func parseRequestToken(c *fiber.Ctx) (*jwt.Token, bool, error) {
 	// Get the Authorization header
 	authHeader := c.Get("Authorization")
-	var tokenString string
 	if authHeader != "" {
-		tokenString = strings.Replace(authHeader, "Bearer ", "", 1)
-	} else {
-		// Try to get token from cookie
-		cookieToken := c.Cookies("authToken")
-		if cookieToken == "" {
-			return c.Status(401).JSON(fiber.Map{
-				"error": "No authorization header or cookie",
-			})
-		}
-		tokenString = cookieToken
+		token, err := parseToken(strings.Replace(authHeader, "Bearer ", "", 1))
+		return token, false, err
 	}
 
-	// Parse and validate the token
+	// Try to get token from cookie
+	tokenString := c.Cookies(accessTokenCookie)
+	if tokenString == "" {
+		return nil, true, errNoToken
+	}
+	token, err := parseToken(tokenString)
+	return token, true, err
+}
+
+// parseToken validates a JWT signed by this server
+func parseToken(tokenString string) (*jwt.Token, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		// Validate the signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -35,24 +49,235 @@ func AuthMiddleware(c *fiber.Ctx) error {
 		}
 		return []byte(os.Getenv("JWT_SECRET")), nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	return token, nil
+}
+
+// Identity is the signed in user a request was made by, taken from the claims of its token
+type Identity struct {
+	UserID    int
+	Username  string
+	Role      string
+	SessionID string
+	// MustChangePassword is set while the user still has the password someone else gave them, and only
+	// PasswordChangeAuthMiddleware lets them through until they replace it
+	MustChangePassword bool
+	// APIKeyID and Scopes are set instead of SessionID for requests made with an API key
+	APIKeyID int
+	Scopes   []string
+}
+
+// HasScope reports whether the request may do what the scope covers. Only API keys are limited by scopes;
+// signed in users can do anything their role allows.
+func (identity Identity) HasScope(scope string) bool {
+	return identity.APIKeyID == 0 || slices.Contains(identity.Scopes, scope)
+}
+
+// roleRanks orders the roles so each one passes the checks for the roles below it
+var roleRanks = map[string]int{
+	models.RoleViewer: 1,
+	models.RoleEditor: 2,
+	models.RoleAdmin:  3,
+}
+
+// identityFromToken reads the user and session a validated token was issued to. Tokens issued before
+// roles and sessions existed lack those claims and are rejected, so their holders sign in again.
+// The session itself is checked separately by checkSession.
+func identityFromToken(token *jwt.Token) (Identity, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return Identity{}, jwt.ErrTokenInvalidClaims
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return Identity{}, err
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		return Identity{}, jwt.ErrTokenInvalidClaims
+	}
+	username, _ := claims["username"].(string)
+	role, _ := claims["role"].(string)
+	sessionID, _ := claims["jti"].(string)
+	if username == "" || !models.IsValidRole(role) || sessionID == "" {
+		return Identity{}, jwt.ErrTokenInvalidClaims
+	}
+
+	// Tokens from before access tokens were short-lived are not accepted for the rest of their week
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		return Identity{}, jwt.ErrTokenInvalidClaims
+	}
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil || expiresAt.Sub(issuedAt.Time) > AccessTokenLifetime {
+		return Identity{}, jwt.ErrTokenInvalidClaims
+	}
+
+	mustChangePassword, _ := claims["must_change_password"].(bool)
+
+	return Identity{UserID: userID, Username: username, Role: role, SessionID: sessionID,
+		MustChangePassword: mustChangePassword}, nil
+}
+
+// AuthMiddleware checks if the user is authenticated with a session that has not been revoked, and
+// stores who they are in c.Locals("user"), with their username also in c.Locals("username").
+// Browsers authenticated by cookie also have to send CSRFHeader with anything but a read.
+// API keys are refused; routes scripts can use take ScopedAuthMiddleware instead. Users who have to change
+// their password are refused too, until they have.
+func AuthMiddleware(c *fiber.Ctx) error {
+	return authenticate(c, "", false)
+}
 
-	if err != nil || !token.Valid {
+// ScopedAuthMiddleware is AuthMiddleware that also accepts API keys in APIKeyHeader with the given scope
+func ScopedAuthMiddleware(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return authenticate(c, scope, false)
+	}
+}
+
+// PasswordChangeAuthMiddleware is AuthMiddleware that also lets through users who have to change their
+// password, for the few routes they need to do so or to sign out
+func PasswordChangeAuthMiddleware(c *fiber.Ctx) error {
+	return authenticate(c, "", true)
+}
+
+// authenticate identifies the user a request was made by, allowing API keys with the scope if it is not ""
+// and users who have to change their password if allowPasswordChange is set
+func authenticate(c *fiber.Ctx, scope string, allowPasswordChange bool) error {
+	if key := c.Get(APIKeyHeader); key != "" {
+		return authenticateAPIKey(c, key, scope)
+	}
+
+	token, fromCookie, err := parseRequestToken(c)
+	if err == errNoToken {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "No authorization header or cookie",
+		})
+	}
+	var identity Identity
+	if err == nil {
+		identity, err = identityFromToken(token)
+	}
+	if err != nil {
 		return c.Status(401).JSON(fiber.Map{
 			"error": "Invalid or expired token",
 		})
 	}
+	if err := checkSession(c, identity.SessionID); err != nil {
+		return c.Status(401).JSON(fiber.Map{
+			"error": "Session has ended, please sign in again",
+		})
+	}
+	// Scripts sending the token in the Authorization header are not at risk, as browsers never add it by themselves
+	if fromCookie && !checkCSRF(c, identity.SessionID) {
+		return c.Status(403).JSON(fiber.Map{
+			"error": "Missing or invalid CSRF token",
+		})
+	}
+	if identity.MustChangePassword && !allowPasswordChange {
+		return passwordChangeRequired(c)
+	}
+
+	c.Locals("user", identity)
+	c.Locals("username", identity.Username)
 
 	// Token is valid, continue
 	return c.Next()
 }
 
-// GenerateToken generates a JWT token for authenticated users
-func GenerateToken(username string) (string, error) {
+// passwordChangeRequired refuses a request from a user who has to change their password first
+func passwordChangeRequired(c *fiber.Ctx) error {
+	return c.Status(403).JSON(fiber.Map{
+		"error":                "Your password has to be changed before you can do anything else",
+		"must_change_password": true,
+	})
+}
+
+// RequireRole lets through users with one of the given roles, or a role above it. It goes after
+// AuthMiddleware, which identifies the user.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		identity, ok := CurrentUser(c)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{
+				"error": "Authentication required",
+			})
+		}
+
+		for _, role := range roles {
+			if RoleAtLeast(identity.Role, role) {
+				return c.Next()
+			}
+		}
+		return c.Status(403).JSON(fiber.Map{
+			"error": "You do not have permission to do this",
+		})
+	}
+}
+
+// RoleAtLeast reports whether a role passes the checks for the minimum role given
+func RoleAtLeast(role string, minimum string) bool {
+	return roleRanks[role] >= roleRanks[minimum]
+}
+
+// CurrentUser returns who AuthMiddleware identified the request as being made by, reporting false on public routes
+func CurrentUser(c *fiber.Ctx) (Identity, bool) {
+	identity, ok := c.Locals("user").(Identity)
+	return identity, ok
+}
+
+// CurrentUsername returns the username AuthMiddleware stored for the request, or "" on public routes
+func CurrentUsername(c *fiber.Ctx) string {
+	username, _ := c.Locals("username").(string)
+	return username
+}
+
+// IsAuthenticated reports whether the request carries a valid token, for public routes
+// that show more to signed in users instead of rejecting everyone else
+func IsAuthenticated(c *fiber.Ctx) bool {
+	_, ok := OptionalUser(c)
+	return ok
+}
+
+// OptionalUser identifies the signed in user a request on a public route was made by, reporting false for
+// anyone else. It checks the request the way AuthMiddleware does, so a browser sending its cookie without the
+// CSRF token, as another site could make it do, counts as anyone else.
+func OptionalUser(c *fiber.Ctx) (Identity, bool) {
+	token, fromCookie, err := parseRequestToken(c)
+	if err != nil {
+		return Identity{}, false
+	}
+	identity, err := identityFromToken(token)
+	if err != nil || identity.MustChangePassword || checkSession(c, identity.SessionID) != nil {
+		return Identity{}, false
+	}
+	if fromCookie && !checkCSRF(c, identity.SessionID) {
+		return Identity{}, false
+	}
+	return identity, true
+}
+
+// GenerateToken generates a short-lived JWT access token for a session of an authenticated user, naming
+// them, their role and the session in its claims. The session's refresh token gets the next one.
+func GenerateToken(user models.User, sessionID string) (string, error) {
 	claims := jwt.MapClaims{
-		"username": username,
-		"exp":      time.Now().Add(time.Hour * 24 * 7).Unix(), // Token expires in 7 days
+		"sub":      strconv.Itoa(user.ID),
+		"username": user.Username,
+		"role":     user.Role,
+		"jti":      sessionID,
+		"exp":      time.Now().Add(AccessTokenLifetime).Unix(),
 		"iat":      time.Now().Unix(),
 	}
+	if user.MustChangePassword {
+		claims["must_change_password"] = true
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
@@ -62,3 +287,39 @@ func GenerateToken(username string) (string, error) {
 
 	return tokenString, nil
 }
+
+// GenerateChallengeToken generates a token showing a user got their password right, to send back with
+// their second factor. It names no role or session, so AuthMiddleware does not accept it.
+func GenerateChallengeToken(userID int) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":     strconv.Itoa(userID),
+		"purpose": twoFactorChallengePurpose,
+		"exp":     time.Now().Add(twoFactorChallengeLifetime).Unix(),
+		"iat":     time.Now().Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// ParseChallengeToken validates a token from GenerateChallengeToken, returning the user it was issued to
+func ParseChallengeToken(tokenString string) (int, error) {
+	token, err := parseToken(tokenString)
+	if err != nil {
+		return 0, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != twoFactorChallengePurpose {
+		return 0, jwt.ErrTokenInvalidClaims
+	}
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return 0, err
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		return 0, jwt.ErrTokenInvalidClaims
+	}
+	return userID, nil
+}
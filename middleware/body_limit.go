@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BodyLimit caps the size of request bodies at defaultLimit bytes, or at the limit given for the path in limits.
+// The server streams request bodies instead of reading them before the handler runs, so this check on the
+// declared length is what keeps a client from sending more than a route needs, and lets routes that refuse
+// the request never read the body at all. Bodies without a length and compressed bodies are refused, since
+// either could grow past the limit once read. What a handler leaves unread is discarded afterwards, so it can't
+// be mistaken for the next request on the connection.
+func BodyLimit(defaultLimit int, limits map[string]int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := defaultLimit
+		if routeLimit, ok := limits[strings.TrimRight(strings.ToLower(c.Path()), "/")]; ok {
+			limit = routeLimit
+		}
+
+		length := c.Request().Header.ContentLength()
+		var status int
+		var message string
+		switch {
+		case length == -1:
+			status, message = 411, "Request bodies must be sent with a Content-Length"
+		case length > limit:
+			status, message = 413, fmt.Sprintf("Request bodies must be at most %d MB", limit>>20)
+		case length > 0 && len(c.Request().Header.ContentEncoding()) > 0:
+			status, message = 415, "Compressed request bodies are not accepted"
+		}
+		if status != 0 {
+			// The body is never read, so the connection can't be used again
+			c.Context().SetConnectionClose()
+			return c.Status(status).JSON(fiber.Map{"error": message})
+		}
+
+		err := c.Next()
+		if stream := c.Context().RequestBodyStream(); stream != nil {
+			if _, drainErr := io.Copy(io.Discard, stream); drainErr != nil {
+				c.Context().SetConnectionClose()
+			}
+		}
+		return err
+	}
+}
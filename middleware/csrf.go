@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Cookies a browser signs in with. The tokens are HttpOnly so scripts on the page cannot read them, and
+// the refresh token is only sent to the auth routes that use it.
+const (
+	accessTokenCookie  = "authToken"
+	refreshTokenCookie = "refreshToken"
+	refreshTokenPath   = "/api/auth"
+	// csrfCookie is the one session cookie scripts can read, so they can copy it into CSRFHeader
+	csrfCookie = "csrfToken"
+)
+
+// CSRFHeader is where requests authenticated by cookie have to repeat their session's CSRF token. Another
+// site can make a browser send the cookies, but cannot read them to fill in the header.
+const CSRFHeader = "X-CSRF-Token"
+
+// CSRFToken is the CSRF token of a session, derived from its ID so it needs no storing and stays the same
+// as the session's access and refresh tokens are replaced
+func CSRFToken(sessionID string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte("csrf:" + sessionID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkCSRF reports whether a request carries the CSRF token of the session it was authenticated with.
+// Reading is allowed without it, as another site cannot see the response.
+func checkCSRF(c *fiber.Ctx, sessionID string) bool {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Get(CSRFHeader)), []byte(CSRFToken(sessionID))) == 1
+}
+
+// setCookie sets a cookie only ever sent back over HTTPS to this site
+func setCookie(c *fiber.Ctx, name string, value string, path string, lifetime time.Duration, httpOnly bool) {
+	c.Cookie(&fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   int(lifetime.Seconds()),
+		Expires:  time.Now().Add(lifetime),
+		Secure:   true,
+		HTTPOnly: httpOnly,
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+}
+
+// SetSessionCookies signs the browser making the request in with a session's tokens
+func SetSessionCookies(c *fiber.Ctx, tokens SessionTokens) {
+	setCookie(c, accessTokenCookie, tokens.AccessToken, "/", AccessTokenLifetime, true)
+	setCookie(c, refreshTokenCookie, tokens.RefreshToken, refreshTokenPath, refreshTokenLifetime, true)
+	setCookie(c, csrfCookie, tokens.CSRFToken, "/", refreshTokenLifetime, false)
+}
+
+// SetAccessTokenCookie replaces the access token of the browser making the request, leaving the rest of its
+// session as it is
+func SetAccessTokenCookie(c *fiber.Ctx, accessToken string) {
+	setCookie(c, accessTokenCookie, accessToken, "/", AccessTokenLifetime, true)
+}
+
+// ClearSessionCookies signs the browser making the request out
+func ClearSessionCookies(c *fiber.Ctx) {
+	deleteCookie(c, accessTokenCookie, "/", true)
+	deleteCookie(c, refreshTokenCookie, refreshTokenPath, true)
+	deleteCookie(c, csrfCookie, "/", false)
+}
+
+// deleteCookie removes a cookie set by setCookie
+func deleteCookie(c *fiber.Ctx, name string, path string, httpOnly bool) {
+	c.Cookie(&fiber.Cookie{
+		Name:     name,
+		Path:     path,
+		Expires:  time.Unix(0, 0),
+		Secure:   true,
+		HTTPOnly: httpOnly,
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+}
+
+// RefreshTokenCookie returns the refresh token the browser making the request was given, or ""
+func RefreshTokenCookie(c *fiber.Ctx) string {
+	return c.Cookies(refreshTokenCookie)
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAuthMiddlewareChecksCSRF(t *testing.T) {
+	setupTestDatabase(t)
+	app := newTestApp()
+	session := startTestSession(t, app)
+	other := startTestSession(t, app)
+
+	tests := []struct {
+		name       string
+		method     string
+		bearer     bool
+		csrf       string
+		wantStatus int
+	}{
+		{name: "cookie read without token", method: fiber.MethodGet, wantStatus: 200},
+		{name: "cookie write with session's token", method: fiber.MethodPost, csrf: session.CSRFToken, wantStatus: 200},
+		{name: "cookie write without token", method: fiber.MethodPost, wantStatus: 403},
+		{name: "cookie write with wrong token", method: fiber.MethodDelete, csrf: "not the token", wantStatus: 403},
+		{name: "cookie write with another session's token", method: fiber.MethodPut, csrf: other.CSRFToken, wantStatus: 403},
+		{name: "bearer write without token", method: fiber.MethodPost, bearer: true, wantStatus: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/private", nil)
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer "+session.AccessToken)
+			} else {
+				req.Header.Set("Cookie", accessTokenCookie+"="+session.AccessToken)
+			}
+			if tt.csrf != "" {
+				req.Header.Set(CSRFHeader, tt.csrf)
+			}
+
+			if status, body := send(t, app, req); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", status, tt.wantStatus, body)
+			}
+		})
+	}
+}
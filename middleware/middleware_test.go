@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// testUser is who the sessions started by startTestSession belong to
+var testUser = models.User{ID: 1, Username: "alice", Role: models.RoleEditor}
+
+// setupTestDatabase gives the test a fresh database in a temporary directory, and a secret to sign tokens with
+func setupTestDatabase(t *testing.T) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test secret")
+	t.Chdir(t.TempDir())
+	if err := config.InitDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(config.CloseDatabase)
+}
+
+// newTestApp serves the routes the tests go through: /login starts a session for testUser, /refresh rotates
+// the refresh token in the body and /private answers 200 to anyone AuthMiddleware lets through
+func newTestApp() *fiber.App {
+	app := fiber.New()
+	app.Post("/login", func(c *fiber.Ctx) error {
+		tokens, err := StartSession(c, testUser)
+		if err != nil {
+			return err
+		}
+		return c.JSON(tokens)
+	})
+	app.Post("/refresh", func(c *fiber.Ctx) error {
+		_, _, next, err := RotateRefreshToken(c, string(c.Body()))
+		if err == ErrInvalidRefreshToken {
+			return c.SendStatus(401)
+		}
+		if err != nil {
+			return err
+		}
+		return c.SendString(next)
+	})
+	app.All("/private", AuthMiddleware, func(c *fiber.Ctx) error {
+		return c.SendStatus(200)
+	})
+	return app
+}
+
+// send makes a request to the test app, returning the status and body of the response
+func send(t *testing.T, app *fiber.App, req *http.Request) (int, string) {
+	t.Helper()
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// startTestSession signs testUser in, returning the tokens of the new session
+func startTestSession(t *testing.T, app *fiber.App) SessionTokens {
+	t.Helper()
+	status, body := send(t, app, httptest.NewRequest(fiber.MethodPost, "/login", nil))
+	if status != 200 {
+		t.Fatalf("starting a session: status %d: %s", status, body)
+	}
+	var tokens SessionTokens
+	if err := json.NewDecoder(strings.NewReader(body)).Decode(&tokens); err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
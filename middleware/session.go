@@ -0,0 +1,309 @@
+package middleware
+
+import (
+	"PersonalWebsiteGO/config"
+	"PersonalWebsiteGO/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// AccessTokenLifetime is how long a token from GenerateToken works for, limiting the harm a leaked one can do
+	AccessTokenLifetime = 15 * time.Minute
+	// refreshTokenLifetime is how long a session lasts without being refreshed before the user has to sign in again
+	refreshTokenLifetime = 7 * 24 * time.Hour
+	// sessionCacheTTL is how long a session found to be active is trusted before the database is asked again.
+	// Sessions revoked through this server leave the cache straight away, so this only delays noticing
+	// sessions removed some other way, and bounds how often last_seen_at is written.
+	sessionCacheTTL = time.Minute
+	// maxCachedSessions is how many sessions the cache holds before it drops the stale ones
+	maxCachedSessions  = 1000
+	maxUserAgentLength = 255
+)
+
+var errSessionRevoked = errors.New("session has been revoked or has expired")
+
+// ErrInvalidRefreshToken is returned for refresh tokens that are unknown, already used or belong to a session that has ended
+var ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// SessionTokens are what a client is given when it signs in or refreshes its session: an access token to send
+// with requests, a single-use refresh token to get the next one with when it expires, and the CSRF token
+// browsers using the cookies from SetSessionCookies repeat in a header
+type SessionTokens struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	CSRFToken    string `json:"csrf_token"`
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// sessionCache remembers which sessions were active when last checked
+var sessionCache = struct {
+	sync.Mutex
+	checked map[string]time.Time
+}{checked: map[string]time.Time{}}
+
+// newSessionID makes a random identifier for a session, used as its access tokens' jti claim
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashRefreshToken is what a refresh token is stored as, so the database alone is no use to anyone who reads it.
+// The tokens are random enough that a fast hash is as good as a slow one.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken makes the next refresh token of a session
+func issueRefreshToken(db execer, sessionID string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	_, err := db.Exec("INSERT INTO refresh_tokens (session_id, token_hash) VALUES (?, ?)", sessionID, hashRefreshToken(token))
+	return token, err
+}
+
+// sessionExpiry is the SQLite datetime modifier for when a session refreshed now will expire
+func sessionExpiry() string {
+	return fmt.Sprintf("+%d seconds", int(refreshTokenLifetime.Seconds()))
+}
+
+// StartSession records a new sign in by the user from the device making the request and
+// returns the tokens for it
+func StartSession(c *fiber.Ctx, user models.User) (SessionTokens, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+
+	userAgent := c.Get(fiber.HeaderUserAgent)
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	// Sessions that ran out long ago are only clutter
+	_, err = config.DB.Exec(`
+		DELETE FROM sessions WHERE expires_at < datetime('now', '-30 days');
+		DELETE FROM refresh_tokens WHERE session_id NOT IN (SELECT id FROM sessions);`)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+
+	_, err = config.DB.Exec(
+		"INSERT INTO sessions (id, user_id, ip_address, user_agent, expires_at) VALUES (?, ?, ?, ?, datetime('now', ?))",
+		id, user.ID, c.IP(), userAgent, sessionExpiry(),
+	)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+
+	refreshToken, err := issueRefreshToken(config.DB, id)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	accessToken, err := GenerateToken(user, id)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+
+	return SessionTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(AccessTokenLifetime.Seconds()),
+		CSRFToken:    CSRFToken(id),
+	}, nil
+}
+
+// RotateRefreshToken uses up a refresh token, extending its session and returning the session's ID, the user it
+// belongs to and the refresh token to use next time. A refresh token is only good once, so seeing one again means
+// it has been copied: the whole session is revoked, cutting off both whoever copied it and the real user.
+func RotateRefreshToken(c *fiber.Ctx, refreshToken string) (string, int, string, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer tx.Rollback()
+
+	var (
+		tokenID   int
+		sessionID string
+		userID    int
+		used      bool
+		active    bool
+	)
+	err = tx.QueryRow(`
+		SELECT r.id, r.session_id, s.user_id, r.used_at IS NOT NULL,
+			s.revoked_at IS NULL AND s.expires_at > CURRENT_TIMESTAMP
+		FROM refresh_tokens r JOIN sessions s ON s.id = r.session_id
+		WHERE r.token_hash = ?`, hashRefreshToken(refreshToken),
+	).Scan(&tokenID, &sessionID, &userID, &used, &active)
+	if err == sql.ErrNoRows {
+		return "", 0, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return "", 0, "", err
+	}
+	if !active {
+		return "", 0, "", ErrInvalidRefreshToken
+	}
+
+	// Claiming the token only if it is still unused settles two refreshes racing each other
+	claimed := false
+	if !used {
+		result, err := tx.Exec("UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = ? AND used_at IS NULL", tokenID)
+		if err != nil {
+			return "", 0, "", err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return "", 0, "", err
+		}
+		claimed = updated > 0
+	}
+	if !claimed {
+		tx.Rollback()
+		if _, err := revokeSessions("id = ?", sessionID); err != nil {
+			return "", 0, "", err
+		}
+		config.LogMessage("ERROR", fmt.Sprintf("Refresh token of session %s for user %d was reused from %s; session revoked", sessionID, userID, c.IP()))
+		return "", 0, "", ErrInvalidRefreshToken
+	}
+
+	next, err := issueRefreshToken(tx, sessionID)
+	if err != nil {
+		return "", 0, "", err
+	}
+	_, err = tx.Exec(`UPDATE sessions SET expires_at = datetime('now', ?), last_seen_at = CURRENT_TIMESTAMP, ip_address = ?
+		WHERE id = ?`, sessionExpiry(), c.IP(), sessionID)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", 0, "", err
+	}
+	return sessionID, userID, next, nil
+}
+
+// checkSession reports whether a session is still active, asking the database at most once per
+// sessionCacheTTL and noting when and where the session was last used while it does
+func checkSession(c *fiber.Ctx, id string) error {
+	sessionCache.Lock()
+	checkedAt, ok := sessionCache.checked[id]
+	sessionCache.Unlock()
+	if ok && time.Since(checkedAt) < sessionCacheTTL {
+		return nil
+	}
+
+	result, err := config.DB.Exec(`UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP, ip_address = ?
+		WHERE id = ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP`, c.IP(), id)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		forgetSessions(id)
+		return errSessionRevoked
+	}
+
+	sessionCache.Lock()
+	defer sessionCache.Unlock()
+	if len(sessionCache.checked) >= maxCachedSessions {
+		for cachedID, at := range sessionCache.checked {
+			if time.Since(at) >= sessionCacheTTL {
+				delete(sessionCache.checked, cachedID)
+			}
+		}
+	}
+	sessionCache.checked[id] = time.Now()
+	return nil
+}
+
+// forgetSessions drops sessions from the cache so the next request using them goes to the database
+func forgetSessions(ids ...string) {
+	sessionCache.Lock()
+	defer sessionCache.Unlock()
+	for _, id := range ids {
+		delete(sessionCache.checked, id)
+	}
+}
+
+// revokeSessions marks the sessions matching the WHERE clause as revoked, reporting how many there were
+func revokeSessions(clause string, args ...any) (int, error) {
+	rows, err := config.DB.Query("UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE revoked_at IS NULL AND "+clause+" RETURNING id", args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	forgetSessions(ids...)
+	return len(ids), nil
+}
+
+// RevokeSession ends one of a user's sessions, reporting false if they have no such active session
+func RevokeSession(userID int, id string) (bool, error) {
+	revoked, err := revokeSessions("user_id = ? AND id = ?", userID, id)
+	return revoked > 0, err
+}
+
+// RevokeUserSessions ends all of a user's sessions except the one given, which may be "" to end them all
+func RevokeUserSessions(userID int, exceptID string) (int, error) {
+	return revokeSessions("user_id = ? AND id != ?", userID, exceptID)
+}
+
+// GetUserSessions lists a user's active sessions, most recently used first, marking the one given as current
+func GetUserSessions(userID int, currentID string) ([]models.Session, error) {
+	rows, err := config.DB.Query(`SELECT id, user_id, ip_address, user_agent, created_at, last_seen_at, expires_at
+		FROM sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY last_seen_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.IPAddress, &session.UserAgent,
+			&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		session.Current = session.ID == currentID
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRotateRefreshToken(t *testing.T) {
+	// uses lists the refresh tokens sent in turn, by the order they were issued in: 0 is the one from signing in,
+	// 1 the one the first refresh gave back and so on. -1 sends a token the server never issued.
+	tests := []struct {
+		name        string
+		uses        []int
+		wantStatus  int
+		wantRevoked bool
+	}{
+		{name: "each token used once", uses: []int{0, 1, 2}, wantStatus: 200},
+		{name: "unknown token", uses: []int{-1}, wantStatus: 401},
+		{name: "token reused straight away", uses: []int{0, 0}, wantStatus: 401, wantRevoked: true},
+		{name: "old token reused after later refreshes", uses: []int{0, 1, 0}, wantStatus: 401, wantRevoked: true},
+		{name: "newest token after a reuse", uses: []int{0, 0, 1}, wantStatus: 401, wantRevoked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDatabase(t)
+			app := newTestApp()
+			session := startTestSession(t, app)
+
+			issued := []string{session.RefreshToken}
+			status := 0
+			for _, use := range tt.uses {
+				token := "never issued"
+				if use >= 0 {
+					token = issued[use]
+				}
+				var body string
+				status, body = send(t, app, httptest.NewRequest(fiber.MethodPost, "/refresh", strings.NewReader(token)))
+				if status == 200 {
+					issued = append(issued, body)
+				}
+			}
+			if status != tt.wantStatus {
+				t.Errorf("last refresh answered %d, want %d", status, tt.wantStatus)
+			}
+
+			// A revoked session's access token stops working too, though it has not expired
+			req := httptest.NewRequest(fiber.MethodGet, "/private", nil)
+			req.Header.Set("Authorization", "Bearer "+session.AccessToken)
+			status, _ = send(t, app, req)
+			if revoked := status == 401; revoked != tt.wantRevoked {
+				t.Errorf("access token answered %d, want the session revoked: %v", status, tt.wantRevoked)
+			}
+		})
+	}
+}
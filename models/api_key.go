@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+// Scopes an API key can be given. A key can only do what its scopes allow and its owner's role permits.
+const (
+	ScopeBlogsRead        = "blogs:read"
+	ScopeBlogsWrite       = "blogs:write"
+	ScopeCommentsModerate = "comments:moderate"
+	ScopeMinecraftCommand = "minecraft:command"
+	ScopeProxmoxRead      = "proxmox:read"
+	ScopeIPRead           = "ip:read"
+)
+
+// ScopeRoles is the role a user needs to be given a key with each scope
+var ScopeRoles = map[string]string{
+	ScopeBlogsRead:        RoleViewer,
+	ScopeBlogsWrite:       RoleEditor,
+	ScopeCommentsModerate: RoleEditor,
+	ScopeMinecraftCommand: RoleAdmin,
+	ScopeProxmoxRead:      RoleAdmin,
+	ScopeIPRead:           RoleAdmin,
+}
+
+// IsValidScope reports whether scope is one an API key can have
+func IsValidScope(scope string) bool {
+	_, ok := ScopeRoles[scope]
+	return ok
+}
+
+// APIKey is a long-lived credential a user creates for scripts, sent in the X-API-Key header. Only the
+// prefix is kept in a form that can be shown again, to tell keys apart.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
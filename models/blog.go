@@ -5,12 +5,87 @@ import (
 	"time"
 )
 
+// Content formats a blog post can be authored in
+const (
+	ContentFormatMarkdown = "markdown"
+	ContentFormatHTML     = "html"
+)
+
+// Publication states of a blog post
+const (
+	BlogStatusDraft     = "draft"
+	BlogStatusPublished = "published"
+	BlogStatusScheduled = "scheduled"
+	BlogStatusArchived  = "archived"
+)
+
 // Blog represents a blog post
 type Blog struct {
-	ID        int           `json:"id"`
-	Title     string        `json:"title"`
-	Content   template.HTML `json:"content"`
-	Author    string        `json:"author"`
-	CreatedAt time.Time     `json:"created_at"`
-	UpdatedAt time.Time     `json:"updated_at"`
-}
\ No newline at end of file
+	ID              int           `json:"id"`
+	Title           string        `json:"title"`
+	Slug            string        `json:"slug"`
+	Content         string        `json:"content"`
+	ContentFormat   string        `json:"content_format"`
+	ContentHTML     template.HTML `json:"content_html"`
+	Author          string        `json:"author"`
+	Status          string        `json:"status"`
+	PublishAt       *time.Time    `json:"publish_at"`
+	Category        string        `json:"category"`
+	Tags            []string      `json:"tags"`
+	Series          string        `json:"series"`
+	SeriesOrder     int           `json:"series_order"`
+	MetaDescription string        `json:"meta_description"`
+	WordCount       int           `json:"word_count"`
+	ReadingTime     int           `json:"reading_time"`
+	TableOfContents []TOCEntry    `json:"table_of_contents"`
+	CanonicalURL    string        `json:"canonical_url"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+}
+
+// PostedAt is the date shown to readers: when the post went live, or when it was written if it has not
+func (b Blog) PostedAt() time.Time {
+	if b.PublishAt != nil {
+		return *b.PublishAt
+	}
+	return b.CreatedAt
+}
+
+// TOCEntry is a heading in a post's table of contents, with the lower level headings under it nested inside
+type TOCEntry struct {
+	Level    int        `json:"level"`
+	ID       string     `json:"id"`
+	Text     string     `json:"text"`
+	Children []TOCEntry `json:"children,omitempty"`
+}
+
+// BlogLink is another post referred to from a post, with just enough to link to it
+type BlogLink struct {
+	ID       int       `json:"id"`
+	Title    string    `json:"title"`
+	Slug     string    `json:"slug"`
+	PostedAt time.Time `json:"posted_at"`
+}
+
+// SeriesNavigation places a post within the series it belongs to
+type SeriesNavigation struct {
+	Name     string     `json:"name"`
+	Position int        `json:"position"`
+	Posts    []BlogLink `json:"posts"`
+	Previous *BlogLink  `json:"previous"`
+	Next     *BlogLink  `json:"next"`
+}
+
+// BlogDetail is a single blog post along with the posts a reader can go on to
+type BlogDetail struct {
+	Blog
+	SeriesNavigation *SeriesNavigation `json:"series_navigation"`
+	Related          []BlogLink        `json:"related"`
+}
+
+// BlogSearchResult is a blog post matched by a search, with the matching terms highlighted
+type BlogSearchResult struct {
+	Blog
+	TitleHighlight template.HTML `json:"title_highlight"`
+	Snippet        template.HTML `json:"snippet"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// BlogRevision is a saved version of a blog post's title, content and author
+type BlogRevision struct {
+	ID            int       `json:"id"`
+	BlogID        int       `json:"blog_id"`
+	Title         string    `json:"title"`
+	Content       string    `json:"content"`
+	ContentFormat string    `json:"content_format"`
+	Author        string    `json:"author"`
+	SavedBy       string    `json:"saved_by"`
+	RestoredFrom  *int      `json:"restored_from"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// Moderation states of a comment
+const (
+	CommentStatusPending  = "pending"
+	CommentStatusApproved = "approved"
+	CommentStatusRejected = "rejected"
+	CommentStatusSpam     = "spam"
+)
+
+// Comment is a reader's response to a blog post, or a reply to another comment on it
+type Comment struct {
+	ID          int       `json:"id"`
+	BlogID      int       `json:"blog_id"`
+	BlogTitle   string    `json:"blog_title,omitempty"`
+	ParentID    *int      `json:"parent_id"`
+	AuthorName  string    `json:"author_name"`
+	AuthorEmail string    `json:"author_email,omitempty"`
+	Content     string    `json:"content"`
+	Status      string    `json:"status,omitempty"`
+	IPAddress   string    `json:"ip_address,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Replies     []Comment `json:"replies,omitempty"`
+}
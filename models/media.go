@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// Media is an uploaded image that can be embedded in blog posts
+type Media struct {
+	ID           int               `json:"id"`
+	Hash         string            `json:"hash"`
+	FileName     string            `json:"file_name"`
+	MimeType     string            `json:"mime_type"`
+	Size         int64             `json:"size"`
+	Width        int               `json:"width"`
+	Height       int               `json:"height"`
+	AltText      string            `json:"alt_text"`
+	URL          string            `json:"url"`
+	ThumbnailURL string            `json:"thumbnail_url"`
+	Variants     map[string]string `json:"variants"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is a sign in on one device, identified by the jti claim of the token it was given
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
@@ -0,0 +1,7 @@
+package models
+
+// TagCount is a tag along with how many posts use it, for building a tag cloud
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
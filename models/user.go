@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+)
+
+// Roles a user can have. Each can do everything the ones after it can:
+// admins also manage accounts and the server, editors write posts and moderate, viewers only read.
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// IsValidRole reports whether role is one of the roles a user can have
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleEditor, RoleViewer:
+		return true
+	}
+	return false
+}
+
+// User is an account that can sign in to manage the site
+type User struct {
+	ID                 int       `json:"id"`
+	Username           string    `json:"username"`
+	Role               string    `json:"role"`
+	PasswordHash       string    `json:"-"`
+	MustChangePassword bool      `json:"must_change_password"`
+	TwoFactorEnabled   bool      `json:"two_factor_enabled"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+	PasswordChangedAt  time.Time `json:"password_changed_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+)
+
+// Verification states of a webmention
+const (
+	WebmentionStatusPending  = "pending"
+	WebmentionStatusVerified = "verified"
+	WebmentionStatusRejected = "rejected"
+)
+
+// Kinds of webmention, worked out from how the source links to the post
+const (
+	WebmentionTypeMention = "mention"
+	WebmentionTypeReply   = "reply"
+	WebmentionTypeLike    = "like"
+	WebmentionTypeRepost  = "repost"
+)
+
+// Webmention is a notification that a page elsewhere on the web links to a blog post
+type Webmention struct {
+	ID          int        `json:"id"`
+	BlogID      int        `json:"blog_id"`
+	BlogTitle   string     `json:"blog_title,omitempty"`
+	Source      string     `json:"source"`
+	Target      string     `json:"target"`
+	Type        string     `json:"type"`
+	AuthorName  string     `json:"author_name"`
+	AuthorURL   string     `json:"author_url"`
+	AuthorPhoto string     `json:"author_photo"`
+	Content     string     `json:"content"`
+	URL         string     `json:"url"`
+	PublishedAt *time.Time `json:"published_at"`
+	Status      string     `json:"status,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// PostedAt is when the mention was published on the source site, or when it was received if that is unknown
+func (w Webmention) PostedAt() time.Time {
+	if w.PublishedAt != nil {
+		return *w.PublishedAt
+	}
+	return w.CreatedAt
+}